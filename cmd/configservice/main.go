@@ -2,6 +2,7 @@ package main
 
 import (
 	"service/internal/engine/javascript"
+	"service/internal/engine/sandbox"
 	"service/internal/model"
 	"service/internal/redis"
 	"service/internal/router"
@@ -16,14 +17,20 @@ func main() {
 		panic(err)
 	}
 
-	model.Run()
-	redis.Setup()
-
 	// init runners
 	if err := javascript.Init(); err != nil {
 		panic(err)
 	}
 
+	// serve as a sandbox worker if started by the sandbox
+	if sandbox.IsWorker() {
+		sandbox.Serve()
+		return
+	}
+
+	model.Run()
+	redis.Setup()
+
 	router.SetupConfigService()
 	router.Run()
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"service/internal/engine/javascript"
+	"service/internal/engine/sandbox"
 	"service/internal/router"
 
 	"github.com/spf13/viper"
@@ -19,6 +20,12 @@ func main() {
 		panic(err)
 	}
 
+	// serve as a sandbox worker if started by the sandbox
+	if sandbox.IsWorker() {
+		sandbox.Serve()
+		return
+	}
+
 	router.SetupPlayground()
 	router.Run()
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"service/internal/engine/javascript"
+	"service/internal/engine/sandbox"
 	"service/internal/model"
 	"service/internal/router"
 
@@ -15,13 +16,19 @@ func main() {
 		panic(err)
 	}
 
-	model.Run()
-
 	// init runners
 	if err := javascript.Init(); err != nil {
 		panic(err)
 	}
 
+	// serve as a sandbox worker if started by the sandbox
+	if sandbox.IsWorker() {
+		sandbox.Serve()
+		return
+	}
+
+	model.Run()
+
 	router.SetupTestService()
 	router.Run()
 }
@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	BudgetTimeout = "time"
+	BudgetSteps   = "step"
+	BudgetDepth   = "recursion depth"
+	BudgetMemory  = "memory"
+)
+
+var ErrBudgetExceeded = errors.New("execution budget exceeded")
+
+var ErrCancelled = errors.New("execution cancelled")
+
+// Budget limits the resources of a single execution. Zero values
+// indicate the global limits.
+type Budget struct {
+	Timeout  time.Duration
+	MaxSteps uint64 // only supported by starlark
+	MaxDepth int    // only supported by javascript and javascript-es2020
+}
+
+// NewBudget creates a budget from the per-code limits, with timeout
+// in milliseconds.
+func NewBudget(timeout int, maxSteps uint64, maxDepth int) Budget {
+	return Budget{
+		Timeout:  time.Duration(timeout) * time.Millisecond,
+		MaxSteps: maxSteps,
+		MaxDepth: maxDepth,
+	}
+}
+
+// Effective returns the budget with unset limits replaced by the limits
+// of the pool, which also serve as the ceiling of the per-code limits.
+func (b Budget) Effective(pool *Pool) Budget {
+	return Budget{
+		Timeout:  ceil(b.Timeout, pool.GetDuration("timeout")*time.Millisecond),
+		MaxSteps: ceil(b.MaxSteps, pool.GetUint64("max-steps")),
+		MaxDepth: ceil(b.MaxDepth, pool.GetInt("max-depth")),
+	}
+}
+
+func ceil[T ~int | ~int64 | ~uint64](val T, limit T) T {
+	if val <= 0 || (limit > 0 && val > limit) {
+		return limit
+	}
+	return val
+}
+
+type BudgetError struct {
+	Kind  string
+	Limit string
+}
+
+func (e *BudgetError) Error() string {
+	if e.Kind == BudgetTimeout {
+		return fmt.Sprintf("execution timeout: exceeded %s budget of %s", e.Kind, e.Limit)
+	}
+	return fmt.Sprintf("execution aborted: exceeded %s budget of %s", e.Kind, e.Limit)
+}
+
+func (e *BudgetError) Unwrap() error {
+	return ErrBudgetExceeded
+}
+
+func TimeoutError(timeout time.Duration) error {
+	return &BudgetError{Kind: BudgetTimeout, Limit: timeout.String()}
+}
+
+func StepsError(maxSteps uint64) error {
+	return &BudgetError{Kind: BudgetSteps, Limit: fmt.Sprintf("%d steps", maxSteps)}
+}
+
+func DepthError(maxDepth int) error {
+	return &BudgetError{Kind: BudgetDepth, Limit: fmt.Sprint(maxDepth)}
+}
+
+func MemoryError(limit uint64) error {
+	return &BudgetError{Kind: BudgetMemory, Limit: fmt.Sprintf("%d MiB", limit>>20)}
+}
+
+// ContextError returns the error after the execution context, derived
+// from parent with the timeout of the budget, is done.
+func ContextError(parent context.Context, timeout time.Duration) error {
+	if err := parent.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrCancelled, err)
+	}
+	return TimeoutError(timeout)
+}
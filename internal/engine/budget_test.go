@@ -0,0 +1,41 @@
+package engine_test
+
+import (
+	"service/internal/engine"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEffectiveBudget(t *testing.T) {
+	viper.Set("timeout", 50)
+	viper.Set("max-steps", 1000)
+	defer viper.Set("timeout", nil)
+	defer viper.Set("max-steps", nil)
+
+	budget := engine.Budget{}.Effective(nil)
+	assert.Equal(t, 50*time.Millisecond, budget.Timeout, "global timeout not applied")
+	assert.Equal(t, uint64(1000), budget.MaxSteps, "global step limit not applied")
+	assert.Equal(t, 0, budget.MaxDepth, "unlimited depth not kept")
+
+	budget = engine.Budget{
+		Timeout:  10 * time.Millisecond,
+		MaxSteps: 5000,
+		MaxDepth: 10,
+	}.Effective(nil)
+	assert.Equal(t, 10*time.Millisecond, budget.Timeout, "code timeout not applied")
+	assert.Equal(t, uint64(1000), budget.MaxSteps, "global ceiling not applied")
+	assert.Equal(t, 10, budget.MaxDepth, "code depth not applied")
+}
+
+func TestBudgetError(t *testing.T) {
+	err := engine.StepsError(1000)
+	assert.ErrorIs(t, err, engine.ErrBudgetExceeded)
+	assert.Equal(t, "execution aborted: exceeded step budget of 1000 steps", err.Error())
+
+	err = engine.TimeoutError(50 * time.Millisecond)
+	assert.ErrorIs(t, err, engine.ErrBudgetExceeded)
+	assert.Equal(t, "execution timeout: exceeded time budget of 50ms", err.Error())
+}
@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// default capacity when not configured
+const DefaultCapacity = 100
+
+type Stats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Size      int    `json:"size"`
+	Capacity  int    `json:"capacity"`
+}
+
+type entry[T any] struct {
+	key        string
+	value      T
+	cachedTime time.Time
+}
+
+// LRU is a least-recently-used cache safe for concurrent access. Entries
+// older than the ttl are treated as missing.
+type LRU[T any] struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	items    map[string]*list.Element
+	order    *list.List // front is the most recently used
+	stats    Stats
+	onEvict  func(value T)
+}
+
+// New creates a cache holding at most capacity entries, each for at
+// most ttl. A non-positive ttl means entries never expire.
+func New[T any](capacity int, ttl time.Duration) *LRU[T] {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+
+	return &LRU[T]{
+		capacity: capacity,
+		ttl:      ttl,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// OnEvict sets the function called with each value leaving the cache by
+// eviction, expiration or Remove, but not when replaced by Add. It is
+// called with the lock held.
+func (c *LRU[T]) OnEvict(f func(value T)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onEvict = f
+}
+
+func (c *LRU[T]) Get(key string) (T, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, exist := c.items[key]
+	if exist && c.ttl > 0 && time.Since(elem.Value.(*entry[T]).cachedTime) > c.ttl {
+		c.remove(elem)
+		exist = false
+	}
+
+	if !exist {
+		c.stats.Misses++
+		var zero T
+		return zero, false
+	}
+
+	c.stats.Hits++
+	c.order.MoveToFront(elem)
+	return elem.Value.(*entry[T]).value, true
+}
+
+func (c *LRU[T]) Add(key string, value T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.add(key, value)
+}
+
+// need to acquire lock before calling
+func (c *LRU[T]) add(key string, value T) {
+	if elem, exist := c.items[key]; exist {
+		e := elem.Value.(*entry[T])
+		e.value = value
+		e.cachedTime = time.Now()
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&entry[T]{
+		key:        key,
+		value:      value,
+		cachedTime: time.Now(),
+	})
+
+	for c.order.Len() > c.capacity {
+		// remove least recently used cache when exceeded
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// GetOrAdd returns the value of the key if cached, or adds the value,
+// reporting whether it was cached. Unlike Get, it is not counted in the
+// stats, e.g. when checking again for a value computed after a miss.
+func (c *LRU[T]) GetOrAdd(key string, value T) (T, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, exist := c.items[key]
+	if exist && c.ttl > 0 && time.Since(elem.Value.(*entry[T]).cachedTime) > c.ttl {
+		c.remove(elem)
+		exist = false
+	}
+
+	if exist {
+		c.order.MoveToFront(elem)
+		return elem.Value.(*entry[T]).value, true
+	}
+
+	c.add(key, value)
+	return value, false
+}
+
+func (c *LRU[T]) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, exist := c.items[key]; exist {
+		c.remove(elem)
+	}
+}
+
+// RemovePrefix removes the entries whose keys start with the prefix.
+func (c *LRU[T]) RemovePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, elem := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(elem)
+		}
+	}
+}
+
+func (c *LRU[T]) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Size = c.order.Len()
+	stats.Capacity = c.capacity
+	return stats
+}
+
+// need to acquire lock before calling
+func (c *LRU[T]) remove(elem *list.Element) {
+	e := elem.Value.(*entry[T])
+	c.order.Remove(elem)
+	delete(c.items, e.key)
+
+	if c.onEvict != nil {
+		c.onEvict(e.value)
+	}
+}
@@ -0,0 +1,103 @@
+package cache_test
+
+import (
+	"service/internal/engine/cache"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvictLeastRecentlyUsed(t *testing.T) {
+	c := cache.New[int](2, 0)
+	c.Add("a", 1)
+	c.Add("b", 2)
+
+	// "a" becomes the most recently used
+	_, hit := c.Get("a")
+	assert.True(t, hit)
+
+	c.Add("c", 3)
+
+	_, hit = c.Get("b")
+	assert.False(t, hit, "least recently used entry not evicted")
+
+	val, hit := c.Get("a")
+	assert.True(t, hit)
+	assert.Equal(t, 1, val)
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Evictions)
+	assert.Equal(t, 2, stats.Size)
+	assert.Equal(t, 2, stats.Capacity)
+}
+
+func TestExpiration(t *testing.T) {
+	c := cache.New[int](2, time.Millisecond)
+	c.Add("a", 1)
+
+	time.Sleep(2 * time.Millisecond)
+
+	_, hit := c.Get("a")
+	assert.False(t, hit, "expired entry returned")
+	assert.Equal(t, 0, c.Stats().Size)
+}
+
+func TestRemove(t *testing.T) {
+	c := cache.New[int](2, 0)
+	c.Add("a", 1)
+	c.Remove("a")
+	c.Remove("b")
+
+	_, hit := c.Get("a")
+	assert.False(t, hit, "removed entry returned")
+}
+
+func TestRemovePrefix(t *testing.T) {
+	c := cache.New[int](3, 0)
+	c.Add("a/1", 1)
+	c.Add("a/2", 2)
+	c.Add("b/1", 3)
+	c.RemovePrefix("a/")
+
+	_, hit := c.Get("a/1")
+	assert.False(t, hit, "removed entry returned")
+	_, hit = c.Get("a/2")
+	assert.False(t, hit, "removed entry returned")
+	_, hit = c.Get("b/1")
+	assert.True(t, hit, "entry of another prefix removed")
+}
+
+func TestOnEvict(t *testing.T) {
+	var evicted []int
+	c := cache.New[int](2, 0)
+	c.OnEvict(func(value int) {
+		evicted = append(evicted, value)
+	})
+
+	c.Add("a", 1)
+	c.Add("a", 2) // replaced
+	c.Add("b", 3)
+	c.Add("c", 4)
+	c.Remove("b")
+
+	assert.Equal(t, []int{2, 3}, evicted, "wrong evicted values")
+}
+
+func TestGetOrAdd(t *testing.T) {
+	c := cache.New[int](2, 0)
+
+	value, exist := c.GetOrAdd("a", 1)
+	assert.False(t, exist, "absent entry reported as cached")
+	assert.Equal(t, 1, value, "added value not returned")
+
+	value, exist = c.GetOrAdd("a", 2)
+	assert.True(t, exist, "cached entry reported as absent")
+	assert.Equal(t, 1, value, "cached value replaced")
+
+	stats := c.Stats()
+	assert.Zero(t, stats.Hits, "GetOrAdd counted as a hit")
+	assert.Zero(t, stats.Misses, "GetOrAdd counted as a miss")
+}
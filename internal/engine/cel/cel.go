@@ -0,0 +1,317 @@
+package cel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"service/internal/engine"
+	"service/internal/engine/cache"
+	"service/internal/utils"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// comprehension iterations between checks of the context
+const interruptCheckFrequency = 100
+
+// types of the declared parameters, keyed by model.Param types
+var paramTypes = map[string]*cel.Type{
+	"string":     cel.StringType,
+	"int":        cel.IntType,
+	"float":      cel.DoubleType,
+	"bool":       cel.BoolType,
+	"array":      cel.ListType(cel.DynType),
+	"dictionary": cel.MapType(cel.StringType, cel.DynType),
+}
+
+// compiled code caches of each pool, keyed by the code and declarations
+var codeCaches = engine.NewPoolLocal(func(pool *engine.Pool) *cache.LRU[cel.Program] {
+	return cache.New[cel.Program](
+		pool.GetInt("code-cache-size"),
+		pool.GetDuration("code-cache-expiration"),
+	)
+})
+
+type Engine struct{}
+
+func init() {
+	engine.Register(Engine{})
+}
+
+func (Engine) Name() string {
+	return "cel"
+}
+
+func (Engine) Run(ctx context.Context, task engine.Task) engine.RunResult {
+	return Run(ctx, task)
+}
+
+func (Engine) Validate(pool *engine.Pool, code string) []*engine.ExecError {
+	return Validate(code)
+}
+
+func (Engine) MetaFields(code string) ([]string, bool) {
+	return engine.ScanMetaFields(code)
+}
+
+func (Engine) Check(code string, decls []engine.ParamDecl) []*engine.ExecError {
+	return Check(code, decls)
+}
+
+func (Engine) ClearCache(code string) {
+	ClearCache(code)
+}
+
+func (Engine) CacheStats(pool *engine.Pool) cache.Stats {
+	return CacheStats(pool)
+}
+
+// version of the interpreter, part of the compiled code cache keys
+var version = engine.ModuleVersion("github.com/google/cel-go")
+
+func cacheKey(code string) string {
+	return engine.CacheKey(Engine{}.Name(), version, code) + "/"
+}
+
+// the declarations a program is compiled against, as a single string
+func declsKey(decls []engine.ParamDecl) string {
+	parts := make([]string, len(decls))
+	for i, decl := range decls {
+		parts[i] = decl.Name + ":" + decl.Type
+	}
+	return strings.Join(parts, ",")
+}
+
+func ClearCache(code string) {
+	// the programs compiled against any declarations
+	key := cacheKey(code)
+	for _, caches := range codeCaches.All() {
+		caches.RemovePrefix(key)
+	}
+}
+
+func CacheStats(pool *engine.Pool) cache.Stats {
+	return codeCaches.Get(pool).Stats()
+}
+
+// newEnv declares the parameters as `p.<name>` of their types, or `p` as
+// a map if no parameters are declared, along with `meta` and `bucket`.
+func newEnv(decls []engine.ParamDecl) (*cel.Env, error) {
+	opts := []cel.EnvOption{
+		cel.CrossTypeNumericComparisons(true),
+		cel.Variable("meta", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Function("bucket", cel.Overload("bucket_string_string_int",
+			[]*cel.Type{cel.StringType, cel.StringType, cel.IntType}, cel.IntType,
+			cel.FunctionBinding(bucket),
+		)),
+	}
+
+	if len(decls) == 0 {
+		opts = append(opts, cel.Variable("p", cel.MapType(cel.StringType, cel.DynType)))
+	}
+
+	for _, decl := range decls {
+		t, ok := paramTypes[decl.Type]
+		if !ok {
+			t = cel.DynType
+		}
+		opts = append(opts, cel.Variable("p."+decl.Name, t))
+	}
+
+	return cel.NewEnv(opts...)
+}
+
+// compile parses and type-checks the code, returning the checked AST or
+// all errors found
+func compile(code string, decls []engine.ParamDecl) (*cel.Env, *cel.Ast, []*engine.ExecError) {
+	env, err := newEnv(decls)
+	if err != nil {
+		return nil, nil, []*engine.ExecError{engine.Describe(err)}
+	}
+
+	ast, iss := env.Parse(code)
+	if iss.Err() != nil {
+		return nil, nil, translateIssues(code, engine.ErrorSyntax, iss)
+	}
+
+	ast, iss = env.Check(ast)
+	if iss.Err() != nil {
+		return nil, nil, translateIssues(code, engine.ErrorType, iss)
+	}
+
+	return env, ast, nil
+}
+
+// Validate parses and type-checks the code with `p` as a map, returning
+// all errors found.
+func Validate(code string) []*engine.ExecError {
+	return Check(code, nil)
+}
+
+// Check parses and type-checks the code against the declared parameters,
+// returning all errors found.
+func Check(code string, decls []engine.ParamDecl) []*engine.ExecError {
+	_, _, errs := compile(code, decls)
+	return errs
+}
+
+// get the program from cache, or compile it
+func getProgram(pool *engine.Pool, code string, decls []engine.ParamDecl) (cel.Program, error) {
+	caches := codeCaches.Get(pool)
+	key := cacheKey(code) + declsKey(decls)
+
+	if prg, cacheExist := caches.Get(key); cacheExist {
+		return prg, nil
+	}
+
+	env, ast, errs := compile(code, decls)
+	if errs != nil {
+		return nil, errs[0]
+	}
+
+	prg, err := env.Program(ast, cel.InterruptCheckFrequency(interruptCheckFrequency))
+	if err != nil {
+		return nil, err
+	}
+
+	caches.Add(key, prg)
+	return prg, nil
+}
+
+// bucket(key, salt, n) maps the salted key into [0, n) with the same
+// hashing as the gray release of the service
+func bucket(args ...ref.Val) ref.Val {
+	key, _ := args[0].Value().(string)
+	salt, _ := args[1].Value().(string)
+	n, _ := args[2].Value().(int64)
+	bucket, err := utils.Bucket(key, salt, n)
+	if err != nil {
+		return types.NewErr("bucket: %v", err)
+	}
+
+	return types.Int(bucket)
+}
+
+// activation of the program, with the parameters decoded into the
+// declared types
+func activation(task engine.Task) (map[string]interface{}, error) {
+	var params map[string]interface{}
+	if err := decodeJSON(task.Params, &params); err != nil {
+		return nil, fmt.Errorf("fail to decode params: %w", err)
+	}
+
+	var meta map[string]interface{}
+	if err := decodeJSON(task.MetaJSON(), &meta); err != nil {
+		return nil, fmt.Errorf("fail to decode meta: %w", err)
+	}
+
+	vars := map[string]interface{}{"meta": fromJSON(meta)}
+
+	if len(task.Decls) == 0 {
+		vars["p"] = fromJSON(params)
+		return vars, nil
+	}
+
+	for _, decl := range task.Decls {
+		val, exist := params[decl.Name]
+		if !exist {
+			continue
+		}
+
+		if num, ok := val.(json.Number); ok && decl.Type == "float" {
+			f, _ := num.Float64()
+			vars["p."+decl.Name] = f
+		} else {
+			vars["p."+decl.Name] = fromJSON(val)
+		}
+	}
+
+	return vars, nil
+}
+
+func decodeJSON(str string, v interface{}) error {
+	dec := json.NewDecoder(strings.NewReader(str))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// convert the decoded JSON, with integers as int64 and other numbers
+// as float64
+func fromJSON(val interface{}) interface{} {
+	switch v := val.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = fromJSON(elem)
+		}
+	case map[string]interface{}:
+		for key, elem := range v {
+			v[key] = fromJSON(elem)
+		}
+	}
+	return val
+}
+
+var valueType = reflect.TypeOf(&structpb.Value{})
+
+// encode the value into JSON, returning nil if not serializable
+func encodeJSON(val ref.Val) []byte {
+	native, err := val.ConvertToNative(valueType)
+	if err != nil {
+		return nil
+	}
+
+	data, err := json.Marshal(native.(*structpb.Value).AsInterface())
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func Run(parent context.Context, task engine.Task) engine.RunResult {
+	budget := task.Budget.Effective(task.Pool)
+
+	prg, err := getProgram(task.Pool, task.Code, task.Decls)
+	if err != nil {
+		return engine.RunResult{Err: err}
+	}
+
+	vars, err := activation(task)
+	if err != nil {
+		return engine.RunResult{Err: &engine.ExecError{Kind: engine.ErrorRuntime, Message: err.Error()}}
+	}
+
+	ctx, cancel := context.WithTimeout(parent, budget.Timeout)
+	defer cancel()
+
+	val, _, err := prg.ContextEval(ctx, vars)
+	if err != nil {
+		if ctx.Err() != nil {
+			return engine.RunResult{Err: engine.ContextError(parent, budget.Timeout)}
+		}
+		return engine.RunResult{Err: &engine.ExecError{Kind: engine.ErrorRuntime, Message: err.Error()}}
+	}
+
+	res := engine.RunResult{JSON: encodeJSON(val)}
+
+	switch {
+	case val.Type() == types.StringType:
+		res.Val = string(val.(types.String))
+	case res.JSON != nil && (val.Type() == types.ListType || val.Type() == types.MapType):
+		res.Val = string(res.JSON)
+	default:
+		res.Val = fmt.Sprint(val.Value())
+	}
+
+	return res
+}
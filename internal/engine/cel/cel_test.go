@@ -0,0 +1,162 @@
+package cel_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"service/internal/engine"
+	"service/internal/engine/cel"
+	"service/internal/utils"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+var ctx = context.Background()
+
+var decls = []engine.ParamDecl{
+	{Name: "version", Type: "int"},
+	{Name: "region", Type: "string"},
+	{Name: "ratio", Type: "float"},
+	{Name: "tags", Type: "array"},
+}
+
+const params = `{"version": 1024, "region": "cn", "ratio": 1, "tags": ["a", "b"]}`
+
+func TestMain(m *testing.M) {
+	viper.SetDefault("timeout", 50)
+
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestParams(t *testing.T) {
+	code := `p.version > 100 && p.region == "cn"`
+	res := cel.Run(ctx, engine.Task{Code: code, Params: params, Decls: decls})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "true", res.Val, "wrong returned result")
+
+	// parameters without declarations
+	res = cel.Run(ctx, engine.Task{Code: code, Params: params})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "true", res.Val, "wrong returned result")
+}
+
+func TestJSONResult(t *testing.T) {
+	cases := map[string]string{
+		`p.region`:                          `"cn"`,
+		`p.version`:                         `1024`,
+		`p.ratio * 2.5`:                     `2.5`,
+		`p.ratio > 0`:                       `true`,
+		`{"arr": [1, 2.5], "tags": p.tags}`: `{"arr":[1,2.5],"tags":["a","b"]}`,
+		`null`:                              `null`,
+		`p.tags.map(t, t + "!")`:            `["a!","b!"]`,
+	}
+
+	for code, expected := range cases {
+		res := cel.Run(ctx, engine.Task{Code: code, Params: params, Decls: decls})
+		assert.NoError(t, res.Err, "runner returned an error")
+		assert.JSONEq(t, expected, string(res.JSON), "wrong JSON result for %s", code)
+	}
+
+	// legacy string representation
+	res := cel.Run(ctx, engine.Task{Code: `p.region`, Params: params, Decls: decls})
+	assert.Equal(t, "cn", res.Val, "wrong returned result")
+
+	res = cel.Run(ctx, engine.Task{Code: `{"a": 1}`, Params: params, Decls: decls})
+	assert.Equal(t, `{"a":1}`, res.Val, "wrong returned result")
+}
+
+func TestTypeCheck(t *testing.T) {
+	assert.Empty(t, cel.Check(`p.version > 100 && p.region == "cn"`, decls))
+
+	errs := cel.Check(`p.version > 100 &&
+p.region > 100`, decls)
+	if assert.Len(t, errs, 1, "type error not reported") {
+		assert.Equal(t, engine.ErrorType, errs[0].Kind, "wrong error kind")
+		assert.Equal(t, 2, errs[0].Line, "wrong error line")
+		assert.Equal(t, 10, errs[0].Column, "wrong error column")
+	}
+
+	errs = cel.Check(`p.versoin > 100`, decls)
+	assert.NotEmpty(t, errs, "undeclared parameter not reported")
+
+	errs = cel.Check(`p.version >`, decls)
+	if assert.NotEmpty(t, errs, "syntax error not reported") {
+		assert.Equal(t, engine.ErrorSyntax, errs[0].Kind, "wrong error kind")
+	}
+
+	// type errors are also reported on execution
+	res := cel.Run(ctx, engine.Task{Code: `p.region > 100`, Params: params, Decls: decls})
+	assert.Equal(t, engine.ErrorType, engine.Describe(res.Err).Kind, "wrong error kind")
+}
+
+func TestValidate(t *testing.T) {
+	assert.Empty(t, cel.Validate(`p.version > 100`))
+	assert.NotEmpty(t, cel.Validate(`p.version >`), "syntax error not reported")
+	assert.NotEmpty(t, cel.Validate(`q.version > 100`), "undeclared name not reported")
+}
+
+func TestRuntimeError(t *testing.T) {
+	res := cel.Run(ctx, engine.Task{Code: `p.version / 0`, Params: params, Decls: decls})
+	err := engine.Describe(res.Err)
+	assert.Equal(t, engine.ErrorRuntime, err.Kind, "wrong error kind")
+	assert.Contains(t, err.Message, "division by zero", "wrong error message")
+
+	res = cel.Run(ctx, engine.Task{Code: `p.version`, Params: `{}`, Decls: decls})
+	assert.Error(t, res.Err, "missing parameter not reported")
+}
+
+func TestMetaAndBucket(t *testing.T) {
+	code := `[meta.device_id, bucket(meta.device_id, "exp", 100)]`
+	meta := `{"platform": "ios", "version": 3, "device_id": "device"}`
+	res := cel.Run(ctx, engine.Task{Code: code, Params: "{}", Meta: meta})
+	assert.NoError(t, res.Err, "runner returned an error")
+	bucket, _ := utils.Bucket("device", "exp", 100)
+	assert.JSONEq(t, fmt.Sprintf(`["device", %d]`, bucket), string(res.JSON))
+
+	res = cel.Run(ctx, engine.Task{Code: `meta.version > 2`, Params: "{}", Meta: meta})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "true", res.Val, "wrong returned result")
+
+	res = cel.Run(ctx, engine.Task{Code: `size(meta)`, Params: "{}"})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "0", res.Val, "meta not defaulted")
+
+	res = cel.Run(ctx, engine.Task{Code: `bucket("a", "b", 0)`, Params: "{}"})
+	assert.ErrorContains(t, res.Err, "n must be a positive integer")
+
+	// out of uint32, not wrapped to 0 buckets
+	res = cel.Run(ctx, engine.Task{Code: `bucket("a", "b", 4294967296)`, Params: "{}"})
+	assert.ErrorContains(t, res.Err, "n must be a positive integer")
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// comprehensions checking the context
+	code := `p.list.all(a, p.list.all(b, p.list.all(c, a + b + c >= 0)))`
+	res := cel.Run(ctx, engine.Task{Code: code, Params: `{"list": [1, 2, 3, 4, 5, 6, 7, 8, 9, 10]}`})
+	assert.ErrorIs(t, res.Err, engine.ErrCancelled, "fail to exit on cancellation")
+}
+
+func TestCacheStats(t *testing.T) {
+	before := cel.CacheStats(nil)
+
+	for i := 0; i < 2; i++ {
+		res := cel.Run(ctx, engine.Task{Code: `"stats"`, Params: "{}"})
+		assert.NoError(t, res.Err, "runner returned an error")
+	}
+
+	// recompiled against other declarations
+	res := cel.Run(ctx, engine.Task{Code: `"stats"`, Params: params, Decls: decls})
+	assert.NoError(t, res.Err, "runner returned an error")
+	cel.ClearCache(`"stats"`)
+
+	after := cel.CacheStats(nil)
+	assert.Equal(t, before.Hits+1, after.Hits, "wrong number of hits")
+	assert.Equal(t, before.Misses+2, after.Misses, "wrong number of misses")
+	assert.Equal(t, before.Size, after.Size, "programs of the code not cleared")
+}
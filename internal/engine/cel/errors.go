@@ -0,0 +1,25 @@
+package cel
+
+import (
+	"service/internal/engine"
+
+	"github.com/google/cel-go/cel"
+)
+
+// translate the issues of parsing or checking, with 0-based columns
+func translateIssues(code string, kind string, iss *cel.Issues) []*engine.ExecError {
+	list := iss.Errors()
+	errs := make([]*engine.ExecError, len(list))
+
+	for i, e := range list {
+		line, column := engine.ClampPosition(code, e.Location.Line(), e.Location.Column()+1)
+		errs[i] = &engine.ExecError{
+			Kind:    kind,
+			Line:    line,
+			Column:  column,
+			Message: e.Message,
+		}
+	}
+
+	return errs
+}
@@ -1,6 +1,85 @@
 package engine
 
+import (
+	"context"
+	"encoding/json"
+	"service/internal/engine/cache"
+	"time"
+)
+
 type RunResult struct {
+	// string representation of the returned value, kept for legacy clients
 	Val string
-	Err error
+	// JSON encoded returned value, nil if the value is not serializable
+	JSON json.RawMessage
+	// output of print statements, nil if not captured
+	Logs []string
+	Err  error
+}
+
+// Task describes a single execution of the code.
+type Task struct {
+	Code   string
+	Params string // JSON encoded parameters
+	Meta   string // JSON encoded config meta, empty for an empty object
+	// declared parameters, checked by the engines with static types
+	Decls  []ParamDecl
+	Budget Budget
+	// whether to capture the output of print statements
+	CaptureLogs bool
+	// pool to execute in, nil for the default pool
+	Pool *Pool
+	// loader of the library modules, nil if not loadable
+	Modules ModuleLoader
+	// store of the compiled programs, nil if not persisted
+	Programs ProgramStore
+	// server time seen by the code, zero for the time the run starts
+	Now time.Time
+}
+
+// ParamDecl declares a parameter of the code, with the type names of
+// model.Param.
+type ParamDecl struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// MetaJSON returns the JSON encoded config meta passed to the code.
+func (task Task) MetaJSON() string {
+	if task.Meta == "" {
+		return "{}"
+	}
+	return task.Meta
+}
+
+// Engine is the common interface of all code execution engines.
+type Engine interface {
+	// Name returns the language name, matching model.Code.Lang
+	Name() string
+	// Run executes the task until it finishes, exceeds the budget or
+	// the context is done
+	Run(ctx context.Context, task Task) RunResult
+	// Validate parses the code without executing it against the settings
+	// of pool, e.g. the allowed modules, returning all errors found
+	Validate(pool *Pool, code string) []*ExecError
+	// ClearCache removes the compiled code in all pools
+	ClearCache(code string)
+	// CacheStats reports the usage of the compiled code cache of pool
+	CacheStats(pool *Pool) cache.Stats
+}
+
+// MetaReader is implemented by the engines whose code reads the meta by
+// field names, so that results can be keyed by the fields read.
+type MetaReader interface {
+	// MetaFields returns the meta fields the code may read, or false if
+	// the code may read the whole meta
+	MetaFields(code string) ([]string, bool)
+}
+
+// TypeChecker is implemented by the engines checking the code against the
+// declared parameter types.
+type TypeChecker interface {
+	// Check validates the code like Engine.Validate, also reporting
+	// the type errors against decls
+	Check(code string, decls []ParamDecl) []*ExecError
 }
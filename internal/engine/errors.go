@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// kinds of execution errors
+const (
+	ErrorSyntax  = "syntax"
+	ErrorType    = "type"
+	ErrorRuntime = "runtime"
+	ErrorTimeout = "timeout"
+	// the request is cancelled by the client
+	ErrorCancelled = "cancelled"
+)
+
+type Frame struct {
+	Name   string `json:"name"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+// ExecError is an error located in the user's code. Line and Column are
+// 1-based and are zero if the position is unknown.
+type ExecError struct {
+	Kind    string `json:"kind"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+	// call stack, innermost frame first
+	Stack []Frame `json:"stack,omitempty"`
+}
+
+func (e *ExecError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s error at line %d, column %d: %s",
+			e.Kind, e.Line, e.Column, e.Message)
+	}
+	return fmt.Sprintf("%s error: %s", e.Kind, e.Message)
+}
+
+// Describe converts an error returned by engines into an ExecError.
+func Describe(err error) *ExecError {
+	if err == nil {
+		return nil
+	}
+
+	var execErr *ExecError
+	if errors.As(err, &execErr) {
+		return execErr
+	}
+
+	if errors.Is(err, ErrCancelled) {
+		return &ExecError{Kind: ErrorCancelled, Message: err.Error()}
+	}
+
+	if errors.Is(err, ErrBudgetExceeded) {
+		return &ExecError{Kind: ErrorTimeout, Message: err.Error()}
+	}
+
+	return &ExecError{Kind: ErrorRuntime, Message: err.Error()}
+}
+
+// ClampPosition moves a position past the end of the code, e.g. of an
+// error reported in the wrapper code, to the end of the code.
+func ClampPosition(code string, line int, column int) (int, int) {
+	if line <= 0 {
+		return 0, 0
+	}
+
+	lines := strings.Split(code, "\n")
+	if line > len(lines) {
+		line = len(lines)
+		column = len(lines[line-1]) + 1
+	}
+
+	if column < 1 {
+		column = 1
+	}
+
+	return line, column
+}
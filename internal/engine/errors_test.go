@@ -0,0 +1,37 @@
+package engine_test
+
+import (
+	"errors"
+	"service/internal/engine"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDescribe(t *testing.T) {
+	assert.Nil(t, engine.Describe(nil))
+
+	err := engine.Describe(engine.TimeoutError(0))
+	assert.Equal(t, engine.ErrorTimeout, err.Kind)
+
+	err = engine.Describe(errors.New("error"))
+	assert.Equal(t, engine.ErrorRuntime, err.Kind)
+	assert.Equal(t, "error", err.Message)
+
+	execErr := &engine.ExecError{Kind: engine.ErrorSyntax, Line: 1, Column: 2, Message: "error"}
+	assert.Equal(t, execErr, engine.Describe(execErr))
+	assert.Equal(t, "syntax error at line 1, column 2: error", execErr.Error())
+}
+
+func TestClampPosition(t *testing.T) {
+	code := "a\nbcd"
+
+	line, column := engine.ClampPosition(code, 2, 2)
+	assert.Equal(t, []int{2, 2}, []int{line, column})
+
+	line, column = engine.ClampPosition(code, 3, 1)
+	assert.Equal(t, []int{2, 4}, []int{line, column}, "position not clamped")
+
+	line, column = engine.ClampPosition(code, -1, 4)
+	assert.Equal(t, []int{0, 0}, []int{line, column}, "position in wrapper code not dropped")
+}
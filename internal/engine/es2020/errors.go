@@ -0,0 +1,107 @@
+package es2020
+
+import (
+	"errors"
+	"regexp"
+	"service/internal/engine"
+	"service/internal/engine/jsvm"
+	"strconv"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/parser"
+)
+
+// name of the frame of the user's top level code
+const mainFrame = "<main>"
+
+// a frame of the stack trace, e.g. `	at f (runner:3:10(5))`
+var frameRegexp = regexp.MustCompile(`^\s*at (?:(.+) \()?(.*):(\d+):(\d+)\(\d+\)\)?$`)
+
+func toUserPos(code string, line int, column int) (int, int) {
+	return engine.ClampPosition(code, line-jsvm.HeaderLines, column)
+}
+
+// translate errors from the wrapped code into the user's code
+func translateError(code string, err error) error {
+	var list parser.ErrorList
+	var syntaxErr *goja.CompilerSyntaxError
+	var exception *goja.Exception
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &list):
+		return translateErrors(code, list)[0]
+	case errors.As(err, &syntaxErr):
+		execErr := &engine.ExecError{Kind: engine.ErrorSyntax, Message: syntaxErr.Message}
+		if syntaxErr.File != nil {
+			pos := syntaxErr.File.Position(syntaxErr.Offset)
+			execErr.Line, execErr.Column = toUserPos(code, pos.Line, pos.Column)
+		}
+		return execErr
+	case errors.As(err, &exception):
+		msg, _, _ := strings.Cut(exception.String(), "\n")
+		execErr := &engine.ExecError{
+			Kind:    engine.ErrorRuntime,
+			Message: msg,
+		}
+
+		for _, str := range strings.Split(exception.String(), "\n")[1:] {
+			match := frameRegexp.FindStringSubmatch(str)
+			if match == nil || match[2] != jsvm.FileName {
+				// native code or library modules
+				continue
+			}
+
+			name := match[1]
+			if name == jsvm.RunnerName {
+				name = mainFrame
+			}
+
+			line, _ := strconv.Atoi(match[3])
+			column, _ := strconv.Atoi(match[4])
+			line, column = toUserPos(code, line, column)
+
+			execErr.Stack = append(execErr.Stack, engine.Frame{
+				Name:   name,
+				Line:   line,
+				Column: column,
+			})
+		}
+
+		if len(execErr.Stack) > 0 {
+			execErr.Line = execErr.Stack[0].Line
+			execErr.Column = execErr.Stack[0].Column
+		}
+
+		return execErr
+	}
+
+	return err
+}
+
+// translate all errors, including each of an error list
+func translateErrors(code string, err error) []*engine.ExecError {
+	if err == nil {
+		return nil
+	}
+
+	list, ok := err.(parser.ErrorList)
+	if !ok {
+		return []*engine.ExecError{engine.Describe(translateError(code, err))}
+	}
+
+	errs := make([]*engine.ExecError, len(list))
+	for i, e := range list {
+		line, column := toUserPos(code, e.Position.Line, e.Position.Column)
+		errs[i] = &engine.ExecError{
+			Kind:    engine.ErrorSyntax,
+			Line:    line,
+			Column:  column,
+			Message: e.Message,
+		}
+	}
+
+	return errs
+}
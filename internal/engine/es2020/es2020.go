@@ -0,0 +1,231 @@
+// Package es2020 executes ES2015+ JavaScript with goja, under the language
+// "javascript-es2020". It follows the semantics of the otto engine, i.e.
+// the same runner pools, budgets, results, logs and library modules, so
+// that configs can be migrated one by one.
+package es2020
+
+import (
+	"context"
+	"errors"
+	"math"
+	"service/internal/engine"
+	"service/internal/engine/cache"
+	"service/internal/engine/jsvm"
+	"service/internal/utils"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/parser"
+)
+
+// frames entered before the runner function
+const runnerDepth = 1
+
+// stack size of runtimes without a depth budget, the default of goja
+const unlimitedDepth = math.MaxInt32
+
+// program run by each runtime on creation
+var template = goja.MustCompile("template", jsvm.Prelude, false)
+
+// runner pools of each pool
+var vmPools = engine.NewPoolLocal(func(pool *engine.Pool) *jsvm.VMPool[*goja.Runtime] {
+	return jsvm.NewVMPool(pool.GetInt("runner-num"), newRuntime)
+})
+
+// error returned when no runner is idle in time
+var ErrBusy = jsvm.ErrBusy
+
+// value used to interrupt an execution
+var errHalt = errors.New("execution halted")
+
+// compiled code caches of each pool
+var codeCaches = jsvm.NewCaches[*goja.Program]()
+
+type Engine struct{}
+
+func init() {
+	engine.Register(Engine{})
+}
+
+func (Engine) Name() string {
+	return "javascript-es2020"
+}
+
+func (Engine) Run(ctx context.Context, task engine.Task) engine.RunResult {
+	return Run(ctx, task)
+}
+
+func (Engine) Validate(pool *engine.Pool, code string) []*engine.ExecError {
+	return Validate(code)
+}
+
+func (Engine) MetaFields(code string) ([]string, bool) {
+	return engine.ScanMetaFields(code)
+}
+
+func (Engine) ClearCache(code string) {
+	ClearCache(code)
+}
+
+func (Engine) CacheStats(pool *engine.Pool) cache.Stats {
+	return CacheStats(pool)
+}
+
+// version of the interpreter, part of the compiled code cache keys
+var version = engine.ModuleVersion("github.com/dop251/goja")
+
+func cacheKey(code string) string {
+	return engine.CacheKey(Engine{}.Name(), version, code)
+}
+
+func ClearCache(code string) {
+	key := cacheKey(code)
+	for _, caches := range codeCaches.All() {
+		caches.Remove(key)
+	}
+}
+
+func CacheStats(pool *engine.Pool) cache.Stats {
+	return codeCaches.Get(pool).Stats()
+}
+
+// Validate parses the code, returning all errors found.
+func Validate(code string) []*engine.ExecError {
+	_, err := parser.ParseFile(nil, jsvm.FileName, jsvm.WrapCode(code), 0)
+	return translateErrors(code, err)
+}
+
+// compile the code, or get the compiled program from cache. Programs are
+// shared by all runtimes.
+func compile(pool *engine.Pool, code string) (*goja.Program, error) {
+	return jsvm.Compile(codeCaches.Get(pool), cacheKey(code), func() (*goja.Program, error) {
+		ast, err := parser.ParseFile(nil, jsvm.FileName, jsvm.WrapCode(code), 0)
+		if err != nil {
+			return nil, err
+		}
+
+		return goja.CompileAST(ast, false)
+	})
+}
+
+// newRuntime creates a runtime with the template and `bucket`
+func newRuntime() *goja.Runtime {
+	vm := goja.New()
+	if _, err := vm.RunProgram(template); err != nil {
+		panic(err)
+	}
+
+	vm.Set("bucket", func(call goja.FunctionCall) goja.Value {
+		key := call.Argument(0).String()
+		salt := call.Argument(1).String()
+		bucket, err := utils.Bucket(key, salt, call.Argument(2).ToInteger())
+		if err != nil {
+			panic(newError(vm, "RangeError", "bucket: "+err.Error()))
+		}
+
+		return vm.ToValue(bucket)
+	})
+
+	return vm
+}
+
+// newError creates an error of the constructor, to be thrown by panicking
+func newError(vm *goja.Runtime, constructor string, msg string) *goja.Object {
+	obj, err := vm.New(vm.Get(constructor), vm.ToValue(msg))
+	if err != nil {
+		panic(err)
+	}
+	return obj
+}
+
+// execute the program, returning the legacy string and JSON results
+func execute(vm *goja.Runtime, prg *goja.Program, task engine.Task) (string, []byte, error) {
+	runner, err := vm.RunProgram(prg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	run, _ := goja.AssertFunction(vm.Get("run"))
+	val, err := run(goja.Undefined(), runner, vm.ToValue(task.Params), vm.ToValue(task.MetaJSON()))
+	if err != nil {
+		return "", nil, err
+	}
+
+	obj := val.ToObject(vm)
+	json := obj.Get("1")
+	if goja.IsUndefined(json) {
+		return obj.Get("0").String(), nil, nil
+	}
+
+	return obj.Get("0").String(), []byte(json.String()), nil
+}
+
+func Run(parent context.Context, task engine.Task) engine.RunResult {
+	budget := task.Budget.Effective(task.Pool)
+	pool := vmPools.Get(task.Pool)
+
+	prg, err := compile(task.Pool, task.Code)
+	if err != nil {
+		return engine.RunResult{Err: translateError(task.Code, err)}
+	}
+
+	vm, err := pool.Checkout(parent, jsvm.WaitTimeout(task.Pool, budget))
+	if err != nil {
+		return engine.RunResult{Err: err}
+	}
+	// interrupted runtimes are reusable, as executions never outlive Run
+	defer func() {
+		vm.ClearInterrupt()
+		pool.Checkin(vm)
+	}()
+
+	if budget.MaxDepth > 0 {
+		// count from the frame of the runner function
+		vm.SetMaxCallStackSize(budget.MaxDepth + runnerDepth)
+	} else {
+		vm.SetMaxCallStackSize(unlimitedDepth)
+	}
+
+	logs := engine.NewLogBuffer(task.CaptureLogs)
+	vm.Set("__print", func(call goja.FunctionCall) goja.Value {
+		logs.Print(call.Argument(0).String())
+		return goja.Undefined()
+	})
+	vm.Set("require", newRequire(vm, task.Pool, task.Modules))
+
+	ctx, cancel := context.WithTimeout(parent, budget.Timeout)
+	defer cancel()
+
+	// interrupt the execution when the context is done, and wait for the
+	// watcher to exit before the vm is returned to the pool
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			vm.Interrupt(errHalt)
+		case <-done:
+		}
+	}()
+
+	res := engine.RunResult{}
+	res.Val, res.JSON, err = execute(vm, prg, task)
+	close(done)
+	<-stopped
+
+	var overflow *goja.StackOverflowError
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		res = engine.RunResult{Err: engine.ContextError(parent, budget.Timeout)}
+	case errors.As(err, &overflow) && budget.MaxDepth > 0:
+		res.Err = engine.DepthError(budget.MaxDepth)
+	case errors.As(err, &overflow):
+		res.Err = &engine.ExecError{Kind: engine.ErrorRuntime, Message: "RangeError: Maximum call stack size exceeded"}
+	default:
+		res.Err = translateError(task.Code, err)
+	}
+
+	res.Logs = logs.Lines()
+	return res
+}
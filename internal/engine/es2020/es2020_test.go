@@ -0,0 +1,390 @@
+package es2020_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"service/internal/engine"
+	"service/internal/engine/es2020"
+	"service/internal/utils"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+var ctx = context.Background()
+
+func TestMain(m *testing.M) {
+	viper.SetDefault("timeout", 50)
+
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestParams(t *testing.T) {
+	code := "return p.version_code;"
+	params := `{"version_code": 1024}`
+	res := es2020.Run(ctx, engine.Task{Code: code, Params: params})
+
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "1024", res.Val, "wrong returned result")
+}
+
+func TestMultiline(t *testing.T) {
+	code := `
+if (p.version_code > 100){
+	return "valid"
+} else {
+	return "invalid"
+}
+`
+	params := `{"version_code": 1024}`
+	res := es2020.Run(ctx, engine.Task{Code: code, Params: params})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "valid", res.Val, "wrong returned result")
+}
+
+func TestInlineFunc(t *testing.T) {
+	code := `
+function judge(version) {
+	if (version > 100){
+		return "valid"
+	} else {
+		return "invalid"
+	}
+}
+
+return judge(p.version_code)
+`
+	params := `{"version_code": 1024}`
+	res := es2020.Run(ctx, engine.Task{Code: code, Params: params})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "valid", res.Val, "wrong returned result")
+}
+
+func TestModernSyntax(t *testing.T) {
+	code := `
+const {version_code: version, tags = []} = p
+const double = (x) => x * 2
+class Checker {
+	constructor(min) { this.min = min }
+	check(v) { return v >= this.min }
+}
+return {
+	valid: new Checker(100).check(version),
+	doubled: [...tags, version].map(double),
+	label: ` + "`v${version}`" + `,
+	missing: p.extra?.field ?? "none",
+}
+`
+	res := es2020.Run(ctx, engine.Task{Code: code, Params: `{"version_code": 1024, "tags": [1]}`})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.JSONEq(t, `{"valid":true,"doubled":[2,2048],"label":"v1024","missing":"none"}`, string(res.JSON))
+}
+
+func TestTimeout(t *testing.T) {
+	code := `
+var i = 0;
+while (i < 10000000000) {
+	i += 1
+}
+`
+	params := `{}`
+	res := es2020.Run(ctx, engine.Task{Code: code, Params: params})
+	assert.Error(t, res.Err, "fail to exit on timeout")
+}
+
+func TestValidate(t *testing.T) {
+	assert.Empty(t, es2020.Validate("return p.version_code;"))
+
+	errs := es2020.Validate("return (;")
+	assert.NotEmpty(t, errs, "syntax error not reported")
+	assert.Equal(t, engine.ErrorSyntax, errs[0].Kind, "wrong error kind")
+	assert.Equal(t, 1, errs[0].Line, "wrong error line")
+}
+
+func TestHitCodeCache(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		res := es2020.Run(ctx, engine.Task{
+			Code:   "return p.version_code;",
+			Params: `{"version_code": 1024}`,
+		})
+		assert.NoError(t, res.Err, "runner returned an error")
+		assert.Equal(t, "1024", res.Val, "wrong returned result")
+	}
+
+	es2020.ClearCache("return p.version_code;")
+}
+
+func TestConcurrentCodeCacheAccess(t *testing.T) {
+	const num = 1000
+	ch := make(chan int, num)
+	for i := 0; i < num; i++ {
+		go func(i int) {
+			code := fmt.Sprintf("return %d", i)
+			es2020.Run(ctx, engine.Task{Code: code, Params: "{}"})
+			es2020.ClearCache(code)
+			ch <- 1
+		}(i)
+	}
+
+	resultNum := 0
+
+	for range ch {
+		resultNum++
+		if resultNum == num {
+			break
+		}
+	}
+}
+
+func TestRecoverAfterTimeout(t *testing.T) {
+	res := es2020.Run(ctx, engine.Task{Code: "while (true) {}", Params: "{}"})
+	assert.Error(t, res.Err, "fail to exit on timeout")
+
+	res = es2020.Run(ctx, engine.Task{Code: "return p.version_code;", Params: `{"version_code": 1024}`})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "1024", res.Val, "wrong returned result")
+}
+
+func TestBusy(t *testing.T) {
+	viper.Set("pools.busy.runner-num", 1)
+	viper.Set("pools.busy.runner-wait-timeout", 1)
+	defer func() {
+		viper.Set("pools.busy.runner-num", nil)
+		viper.Set("pools.busy.runner-wait-timeout", nil)
+	}()
+	pool := engine.GetPool("busy")
+
+	done := make(chan int)
+	go func() {
+		es2020.Run(ctx, engine.Task{Code: "while (true) {}", Params: "{}", Pool: pool})
+		done <- 1
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	res := es2020.Run(ctx, engine.Task{Code: "return 1;", Params: "{}", Pool: pool})
+	assert.ErrorIs(t, res.Err, es2020.ErrBusy)
+
+	<-done
+}
+
+func TestDepthBudget(t *testing.T) {
+	code := `
+function fib(n) {
+	return n < 2 ? n : fib(n - 1) + fib(n - 2)
+}
+return fib(10)
+`
+	res := es2020.Run(ctx, engine.Task{Code: code, Params: "{}"})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "55", res.Val, "wrong returned result")
+
+	res = es2020.Run(ctx, engine.Task{Code: code, Params: "{}", Budget: engine.Budget{MaxDepth: 5}})
+	assert.ErrorIs(t, res.Err, engine.ErrBudgetExceeded, "fail to exit on recursion depth")
+
+	// errors thrown by the code are not taken as overflows
+	code = `throw new RangeError("Maximum call stack size exceeded by fib")`
+	res = es2020.Run(ctx, engine.Task{Code: code, Params: "{}", Budget: engine.Budget{MaxDepth: 5}})
+	assert.NotErrorIs(t, res.Err, engine.ErrBudgetExceeded, "thrown error taken as an overflow")
+	assert.ErrorContains(t, res.Err, "exceeded by fib")
+}
+
+func TestCodeTimeout(t *testing.T) {
+	start := time.Now()
+	res := es2020.Run(ctx, engine.Task{
+		Code:   "while (true) {}",
+		Params: "{}",
+		Budget: engine.Budget{Timeout: 5 * time.Millisecond},
+	})
+	assert.ErrorIs(t, res.Err, engine.ErrBudgetExceeded, "fail to exit on timeout")
+	assert.Less(t, time.Since(start), 50*time.Millisecond, "code timeout not applied")
+}
+
+func TestJSONResult(t *testing.T) {
+	cases := map[string]string{
+		`return "valid"`:                  `"valid"`,
+		`return true`:                     `true`,
+		`return p.version_code`:           `1024`,
+		`return {"arr": [1, 2.5]}`:        `{"arr":[1,2.5]}`,
+		`return [null, "a", false, 0]`:    `[null,"a",false,0]`,
+		`return {"nested": {"val": "s"}}`: `{"nested":{"val":"s"}}`,
+	}
+
+	for code, expected := range cases {
+		res := es2020.Run(ctx, engine.Task{Code: code, Params: `{"version_code": 1024}`})
+		assert.NoError(t, res.Err, "runner returned an error")
+		assert.JSONEq(t, expected, string(res.JSON), "wrong JSON result for %s", code)
+	}
+
+	// not representable in JSON
+	for _, code := range []string{"", "return function () {}"} {
+		res := es2020.Run(ctx, engine.Task{Code: code, Params: "{}"})
+		assert.NoError(t, res.Err, "runner returned an error")
+		assert.Nil(t, res.JSON, "JSON result of %s not nil", code)
+	}
+
+	// legacy string representation
+	res := es2020.Run(ctx, engine.Task{Code: "", Params: "{}"})
+	assert.Equal(t, "undefined", res.Val, "wrong returned result")
+}
+
+func TestCaptureLogs(t *testing.T) {
+	code := `
+console.log("version", p.version_code, {"a": 1})
+console.error("error")
+return 1
+`
+	params := `{"version_code": 1024}`
+	res := es2020.Run(ctx, engine.Task{Code: code, Params: params, CaptureLogs: true})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, []string{`version 1024 {"a":1}`, "error"}, res.Logs, "wrong captured logs")
+
+	res = es2020.Run(ctx, engine.Task{Code: code, Params: params})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Nil(t, res.Logs, "logs not discarded")
+}
+
+func TestErrorPosition(t *testing.T) {
+	cases := []struct {
+		code   string
+		kind   string
+		line   int
+		column int
+	}{
+		{"var x = 1\nreturn (", engine.ErrorSyntax, 2, 9},
+		{"var x = 1\n  return y", engine.ErrorRuntime, 2, 10},
+		{"function f(a) {\n  return a.b.c\n}\nreturn f({})", engine.ErrorRuntime, 2, 14},
+	}
+
+	for _, c := range cases {
+		res := es2020.Run(ctx, engine.Task{Code: c.code, Params: "{}"})
+		err := engine.Describe(res.Err)
+		assert.Equal(t, c.kind, err.Kind, "wrong error kind for %s", c.code)
+		assert.Equal(t, c.line, err.Line, "wrong error line for %s", c.code)
+		assert.Equal(t, c.column, err.Column, "wrong error column for %s", c.code)
+	}
+
+	res := es2020.Run(ctx, engine.Task{Code: "function f(a) {\n  return a.b.c\n}\nreturn f({})", Params: "{}"})
+	assert.Equal(t, []engine.Frame{
+		{Name: "f", Line: 2, Column: 14},
+		{Name: "<main>", Line: 4, Column: 9},
+	}, engine.Describe(res.Err).Stack, "wrong call stack")
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(5*time.Millisecond, cancel)
+
+	res := es2020.Run(ctx, engine.Task{Code: "while (true) {}", Params: "{}"})
+	assert.ErrorIs(t, res.Err, engine.ErrCancelled, "fail to exit on cancellation")
+
+	// cancelled before checking out a runner
+	res = es2020.Run(ctx, engine.Task{Code: "return 1", Params: "{}"})
+	assert.ErrorIs(t, res.Err, engine.ErrCancelled, "cancelled context not respected")
+}
+
+// loader of the modules in the map
+func mapLoader(modules map[string]string) engine.ModuleLoader {
+	return func(lang, name string) (*engine.Module, error) {
+		code, exist := modules[name]
+		if !exist {
+			return nil, fmt.Errorf("%w '%s'", engine.ErrUnknownModule, name)
+		}
+		return &engine.Module{Name: name, Version: 1, Code: code}, nil
+	}
+}
+
+func TestRequire(t *testing.T) {
+	loader := mapLoader(map[string]string{
+		"lib/helpers": `var base = require("lib/base").base
+exports.add = function (x) { return x + base }`,
+		"lib/base": `module.exports = { base: 100 }`,
+		"lib/a":    `require("lib/b")`,
+		"lib/b":    `require("lib/a")`,
+		"lib/loop": `while (true) {}`,
+	})
+
+	code := `return require("lib/helpers").add(p.version_code)`
+	res := es2020.Run(ctx, engine.Task{Code: code, Params: `{"version_code": 1}`, Modules: loader})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "101", res.Val, "wrong returned result")
+
+	// assignments to frozen objects throw in strict mode
+	code = `var base = require("lib/base"); base.base = 1; return base.base`
+	res = es2020.Run(ctx, engine.Task{Code: code, Params: "{}", Modules: loader})
+	assert.ErrorContains(t, res.Err, "read only property", "loaded module not frozen")
+
+	res = es2020.Run(ctx, engine.Task{Code: `return require("lib/a")`, Params: "{}", Modules: loader})
+	assert.ErrorContains(t, res.Err, "circular module load: lib/a -> lib/b -> lib/a")
+
+	res = es2020.Run(ctx, engine.Task{Code: `return require("lib/none")`, Params: "{}", Modules: loader})
+	assert.ErrorContains(t, res.Err, "unknown module 'lib/none'")
+	assert.Equal(t, 1, engine.Describe(res.Err).Line, "wrong error line")
+
+	res = es2020.Run(ctx, engine.Task{Code: `return require("lib/base")`, Params: "{}"})
+	assert.ErrorContains(t, res.Err, "unknown module 'lib/base'", "module loaded without a loader")
+
+	res = es2020.Run(ctx, engine.Task{Code: `return require("lib/loop")`, Params: "{}", Modules: loader})
+	assert.ErrorIs(t, res.Err, engine.ErrBudgetExceeded, "module not interrupted")
+}
+
+func TestRequireJavaScriptLibrary(t *testing.T) {
+	libraries := map[string]map[string]string{
+		"javascript": {
+			"lib/base":   `module.exports = { base: 100 }`,
+			"lib/shared": `exports.name = "javascript"`,
+		},
+		"javascript-es2020": {
+			"lib/shared": `exports.name = "es2020"`,
+		},
+	}
+	loader := func(lang, name string) (*engine.Module, error) {
+		code, exist := libraries[lang][name]
+		if !exist {
+			return nil, fmt.Errorf("%w '%s'", engine.ErrUnknownModule, name)
+		}
+		return &engine.Module{Lang: lang, Name: name, Version: 1, Code: code}, nil
+	}
+
+	// libraries published for the otto engine are loadable
+	res := es2020.Run(ctx, engine.Task{Code: `return require("lib/base").base`, Params: "{}", Modules: loader})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "100", res.Val, "javascript library not loaded")
+
+	// libraries of the same name and version published for es2020 are
+	// preferred, and not mixed up with the javascript ones
+	res = es2020.Run(ctx, engine.Task{Code: `return require("lib/shared").name`, Params: "{}", Modules: loader})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "es2020", res.Val, "es2020 library not preferred")
+
+	delete(libraries["javascript-es2020"], "lib/shared")
+	res = es2020.Run(ctx, engine.Task{Code: `return require("lib/shared").name`, Params: "{}", Modules: loader})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "javascript", res.Val, "compiled es2020 library reused for javascript")
+
+	res = es2020.Run(ctx, engine.Task{Code: `return require("lib/none")`, Params: "{}", Modules: loader})
+	assert.ErrorContains(t, res.Err, "unknown module 'lib/none'")
+}
+
+func TestMetaAndBucket(t *testing.T) {
+	code := `return [meta.device_id, bucket(meta.device_id, "exp", 100)]`
+	meta := `{"platform": "ios", "version": 3, "device_id": "device"}`
+	res := es2020.Run(ctx, engine.Task{Code: code, Params: "{}", Meta: meta})
+	assert.NoError(t, res.Err, "runner returned an error")
+	bucket, _ := utils.Bucket("device", "exp", 100)
+	assert.JSONEq(t, fmt.Sprintf(`["device", %d]`, bucket), string(res.JSON))
+
+	res = es2020.Run(ctx, engine.Task{Code: `return meta`, Params: "{}"})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.JSONEq(t, `{}`, string(res.JSON), "meta not defaulted")
+
+	res = es2020.Run(ctx, engine.Task{Code: `return bucket("a", "b", 0)`, Params: "{}"})
+	assert.ErrorContains(t, res.Err, "n must be a positive integer")
+
+	// out of uint32, not wrapped to 0 buckets
+	res = es2020.Run(ctx, engine.Task{Code: `return bucket("a", "b", 2 ** 32)`, Params: "{}"})
+	assert.ErrorContains(t, res.Err, "n must be a positive integer")
+}
@@ -0,0 +1,88 @@
+package es2020
+
+import (
+	"errors"
+	"service/internal/engine"
+	"service/internal/engine/jsvm"
+
+	"github.com/dop251/goja"
+)
+
+// language of the otto engine, whose libraries are loadable by the code
+// too, so that configs can be migrated one by one
+const fallbackLang = "javascript"
+
+// compiled library module caches of each pool, keyed by module language
+// and version
+var moduleCaches = jsvm.NewCaches[*goja.Program]()
+
+// withFallback resolves the modules of the language, then the libraries
+// of the otto engine if not found
+func withFallback(modules engine.ModuleLoader) engine.ModuleLoader {
+	if modules == nil {
+		return nil
+	}
+
+	return func(lang, name string) (*engine.Module, error) {
+		module, err := modules(lang, name)
+		if errors.Is(err, engine.ErrUnknownModule) {
+			return modules(fallbackLang, name)
+		}
+		return module, err
+	}
+}
+
+func compileModule(pool *engine.Pool, module *engine.Module) (*goja.Program, error) {
+	key := module.Lang + ":" + module.Key()
+	return jsvm.Compile(moduleCaches.Get(pool), key, func() (*goja.Program, error) {
+		return goja.Compile(module.Name, jsvm.WrapModule(module.Code), false)
+	})
+}
+
+// execute the module, returning its frozen exports
+func execModule(vm *goja.Runtime, pool *engine.Pool, module *engine.Module) (goja.Value, error) {
+	prg, err := compileModule(pool, module)
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := vm.RunProgram(prg)
+	if err != nil {
+		return nil, err
+	}
+
+	factory, _ := goja.AssertFunction(val)
+	moduleObj := vm.NewObject()
+	exports := vm.NewObject()
+	moduleObj.Set("exports", exports)
+
+	if _, err := factory(goja.Undefined(), moduleObj, exports, vm.Get("require")); err != nil {
+		return nil, err
+	}
+
+	freeze, _ := goja.AssertFunction(vm.Get("Object").ToObject(vm).Get("freeze"))
+	return freeze(goja.Undefined(), moduleObj.Get("exports"))
+}
+
+// newRequire returns the `require` function of a run, throwing an Error
+// if the module cannot be loaded
+func newRequire(vm *goja.Runtime, pool *engine.Pool, modules engine.ModuleLoader) func(goja.FunctionCall) goja.Value {
+	require := jsvm.NewRequire(Engine{}.Name(), withFallback(modules), func(module *engine.Module) (goja.Value, error) {
+		return execModule(vm, pool, module)
+	})
+
+	return func(call goja.FunctionCall) goja.Value {
+		exports, err := require(call.Argument(0).String())
+
+		// interruptions are not catchable by the code
+		var interrupted *goja.InterruptedError
+		if errors.As(err, &interrupted) {
+			panic(interrupted)
+		}
+		if err != nil {
+			panic(newError(vm, "Error", err.Error()))
+		}
+
+		return exports
+	}
+}
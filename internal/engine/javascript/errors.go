@@ -0,0 +1,92 @@
+package javascript
+
+import (
+	"regexp"
+	"service/internal/engine"
+	"service/internal/engine/jsvm"
+	"strconv"
+	"strings"
+
+	"github.com/robertkrimen/otto"
+	"github.com/robertkrimen/otto/parser"
+)
+
+// name of the frame of the user's top level code
+const mainFrame = "<main>"
+
+// a frame of the stack trace, e.g. `    at f (runner:3:10)`
+var frameRegexp = regexp.MustCompile(`^\s*at (?:(.+) \()?(.*):(\d+):(\d+)\)?$`)
+
+func toUserPos(code string, line int, column int) (int, int) {
+	return engine.ClampPosition(code, line-jsvm.HeaderLines, column)
+}
+
+// translate errors from the wrapped code into the user's code
+func translateError(code string, err error) error {
+	switch e := err.(type) {
+	case parser.ErrorList:
+		return translateErrors(code, e)[0]
+	case *otto.Error:
+		execErr := &engine.ExecError{
+			Kind:    engine.ErrorRuntime,
+			Message: e.Error(),
+		}
+
+		for _, str := range strings.Split(e.String(), "\n")[1:] {
+			match := frameRegexp.FindStringSubmatch(str)
+			if match == nil || match[2] != jsvm.FileName {
+				// native code or the wrapper
+				continue
+			}
+
+			name := match[1]
+			if name == jsvm.RunnerName {
+				name = mainFrame
+			}
+
+			line, _ := strconv.Atoi(match[3])
+			column, _ := strconv.Atoi(match[4])
+			line, column = toUserPos(code, line, column)
+
+			execErr.Stack = append(execErr.Stack, engine.Frame{
+				Name:   name,
+				Line:   line,
+				Column: column,
+			})
+		}
+
+		if len(execErr.Stack) > 0 {
+			execErr.Line = execErr.Stack[0].Line
+			execErr.Column = execErr.Stack[0].Column
+		}
+
+		return execErr
+	}
+
+	return err
+}
+
+// translate all errors, including each of an error list
+func translateErrors(code string, err error) []*engine.ExecError {
+	if err == nil {
+		return nil
+	}
+
+	list, ok := err.(parser.ErrorList)
+	if !ok {
+		return []*engine.ExecError{engine.Describe(translateError(code, err))}
+	}
+
+	errs := make([]*engine.ExecError, len(list))
+	for i, e := range list {
+		line, column := toUserPos(code, e.Position.Line, e.Position.Column)
+		errs[i] = &engine.ExecError{
+			Kind:    engine.ErrorSyntax,
+			Line:    line,
+			Column:  column,
+			Message: e.Message,
+		}
+	}
+
+	return errs
+}
@@ -2,96 +2,235 @@ package javascript
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"service/internal/engine"
+	"service/internal/engine/cache"
+	"service/internal/engine/jsvm"
+	"service/internal/utils"
 	"sync"
-	"time"
 
 	"github.com/robertkrimen/otto"
-	"github.com/spf13/viper"
+	"github.com/robertkrimen/otto/parser"
 )
 
-const code = `
-function run(code, p) {
-    "use strict"
-    p = JSON.parse(p)
+// scopes entered before the runner function
+const runnerDepth = 2
 
-    eval("function runner (p) {\n" + code + "\n}")
-    var ret = eval("runner(p)")
+// runner pools of each pool
+var vmPools *engine.PoolLocal[*jsvm.VMPool[*otto.Otto]]
 
-    if (typeof ret === "object" ||
-        typeof ret === "array") {
-        return JSON.stringify(ret)
-    }
-    return ret
+// error returned when no runner is idle in time
+var ErrBusy = jsvm.ErrBusy
+
+// value used to halt an interrupted execution
+var errHalt = errors.New("execution halted")
+
+// compiled code caches of each pool
+var codeCaches = jsvm.NewCaches[*otto.Script]()
+
+func Init() error {
+	// create template
+	templateEngine := otto.New()
+	if _, err := templateEngine.Run(jsvm.Prelude); err != nil {
+		return err
+	}
+	if err := templateEngine.Set("bucket", bucket); err != nil {
+		return err
+	}
+
+	// vms are copied from the template one at a time
+	var templateLock sync.Mutex
+	copyTemplate := func() *otto.Otto {
+		templateLock.Lock()
+		defer templateLock.Unlock()
+
+		return templateEngine.Copy()
+	}
+
+	vmPools = engine.NewPoolLocal(func(pool *engine.Pool) *jsvm.VMPool[*otto.Otto] {
+		return jsvm.NewVMPool(pool.GetInt("runner-num"), copyTemplate)
+	})
+
+	return nil
 }
-`
 
-type jsEngine struct {
-	mu      sync.Mutex
-	engine  *otto.Otto
-	running bool
+type Engine struct{}
+
+func init() {
+	engine.Register(Engine{})
 }
 
-// max concurrent runners
-const runnerNum = 20
+func (Engine) Name() string {
+	return "javascript"
+}
 
-// runner pool
-var engines [runnerNum]*jsEngine
+func (Engine) Run(ctx context.Context, task engine.Task) engine.RunResult {
+	return Run(ctx, task)
+}
 
-func getEngine() *jsEngine {
-	for _, engine := range engines {
-		if !engine.running {
-			engine.running = true
-			return engine
-		}
+func (Engine) Validate(pool *engine.Pool, code string) []*engine.ExecError {
+	return Validate(code)
+}
+
+func (Engine) MetaFields(code string) ([]string, bool) {
+	return engine.ScanMetaFields(code)
+}
+
+func (Engine) ClearCache(code string) {
+	ClearCache(code)
+}
+
+func (Engine) CacheStats(pool *engine.Pool) cache.Stats {
+	return CacheStats(pool)
+}
+
+// version of the interpreter, part of the compiled code cache keys
+var version = engine.ModuleVersion("github.com/robertkrimen/otto")
+
+func cacheKey(code string) string {
+	return engine.CacheKey(Engine{}.Name(), version, code)
+}
+
+func ClearCache(code string) {
+	key := cacheKey(code)
+	for _, caches := range codeCaches.All() {
+		caches.Remove(key)
 	}
-	return engines[0]
 }
 
-func Init() error {
-	// create template
-	templateEngine := otto.New()
-	if _, err := templateEngine.Run(code); err != nil {
-		return err
+func CacheStats(pool *engine.Pool) cache.Stats {
+	return codeCaches.Get(pool).Stats()
+}
+
+// Validate parses the code, returning all errors found.
+func Validate(code string) []*engine.ExecError {
+	_, err := parser.ParseFile(nil, jsvm.FileName, jsvm.WrapCode(code), 0)
+	return translateErrors(code, err)
+}
+
+// compile the code with the given vm, or get the compiled script from cache
+func compile(vm *otto.Otto, pool *engine.Pool, code string) (*otto.Script, error) {
+	return jsvm.Compile(codeCaches.Get(pool), cacheKey(code), func() (*otto.Script, error) {
+		return vm.Compile(jsvm.FileName, jsvm.WrapCode(code))
+	})
+}
+
+// bucket(key, salt, n) maps the salted key into [0, n) with the same
+// hashing as the gray release of the service
+func bucket(call otto.FunctionCall) otto.Value {
+	key := call.Argument(0).String()
+	salt := call.Argument(1).String()
+	n, err := call.Argument(2).ToInteger()
+	if err != nil {
+		n = 0
 	}
 
-	for i := 0; i < runnerNum; i++ {
-		engines[i] = &jsEngine{engine: templateEngine.Copy()}
+	bucket, err := utils.Bucket(key, salt, n)
+	if err != nil {
+		panic(call.Otto.MakeRangeError("bucket: " + err.Error()))
 	}
 
-	return nil
+	val, _ := otto.ToValue(bucket)
+	return val
 }
 
-func Run(id string, code string, params string) engine.RunResult {
-	e := getEngine()
-	// acquire lock
-	e.mu.Lock()
-	// release lock on exit
-	defer e.mu.Unlock()
+// error raised when the limit of SetStackDepthLimit is exceeded, which is
+// only distinguishable by its value as otto does not export the error name
+const stackOverflow = "RangeError: Maximum call stack size exceeded"
 
-	timeout := viper.GetDuration("timeout")
-	ctx, cancel := context.WithTimeout(context.Background(), timeout*time.Millisecond)
-	defer cancel()
+func isStackOverflow(err error) bool {
+	var ottoErr *otto.Error
+	return errors.As(err, &ottoErr) && ottoErr.Error() == stackOverflow
+}
 
-	defer func() {
-		// reset the running state
-		e.running = false
-	}()
+// parse the result array returned by `run`
+func parseResult(val otto.Value) (string, []byte, error) {
+	obj := val.Object()
+	if obj == nil {
+		return "", nil, errors.New("internal error: unexpected result " + val.String())
+	}
+
+	str, err := obj.Get("0")
+	if err != nil {
+		return "", nil, err
+	}
+
+	json, err := obj.Get("1")
+	if err != nil {
+		return "", nil, err
+	}
+	if json.IsUndefined() {
+		return str.String(), nil, nil
+	}
+
+	return str.String(), []byte(json.String()), nil
+}
+
+func Run(parent context.Context, task engine.Task) engine.RunResult {
+	budget := task.Budget.Effective(task.Pool)
+	pool := vmPools.Get(task.Pool)
+
+	vm, err := pool.Checkout(parent, jsvm.WaitTimeout(task.Pool, budget))
+	if err != nil {
+		return engine.RunResult{Err: err}
+	}
+
+	script, err := compile(vm, task.Pool, task.Code)
+	if err != nil {
+		checkin(pool, vm)
+		return engine.RunResult{Err: translateError(task.Code, err)}
+	}
+
+	if budget.MaxDepth > 0 {
+		// count from the scope of the runner function
+		vm.SetStackDepthLimit(budget.MaxDepth + runnerDepth)
+	} else {
+		vm.SetStackDepthLimit(0)
+	}
+
+	logs := engine.NewLogBuffer(task.CaptureLogs)
+	vm.Set("__print", func(call otto.FunctionCall) otto.Value {
+		logs.Print(call.Argument(0).String())
+		return otto.UndefinedValue()
+	})
+	vm.Set("require", newRequire(vm, task.Pool, task.Modules))
+
+	ctx, cancel := context.WithTimeout(parent, budget.Timeout)
+	defer cancel()
 
 	// interrupt channel
-	e.engine.Interrupt = make(chan func(), 1)
+	vm.Interrupt = make(chan func(), 1)
 	// result channel
 	ch := make(chan engine.RunResult, 1)
 
 	go func() {
 		res := engine.RunResult{}
 
-		val, err := e.engine.Call("run", nil, code, params)
-		res.Err = err
+		defer func() {
+			if caught := recover(); caught != nil {
+				if caught != errHalt {
+					panic(caught)
+				}
+				// the result is discarded on interruption
+			}
+		}()
+
+		runner, err := vm.Run(script)
+		if err != nil {
+			res.Err = translateError(task.Code, err)
+			ch <- res
+			return
+		}
+
+		val, err := vm.Call("run", nil, runner, task.Params, task.MetaJSON())
+		res.Err = translateError(task.Code, err)
+
+		if budget.MaxDepth > 0 && isStackOverflow(err) {
+			res.Err = engine.DepthError(budget.MaxDepth)
+		}
 
 		if err == nil {
-			res.Val, res.Err = val.ToString()
+			res.Val, res.JSON, res.Err = parseResult(val)
 		}
 		ch <- res
 	}()
@@ -100,12 +239,23 @@ func Run(id string, code string, params string) engine.RunResult {
 
 	select {
 	case <-ctx.Done():
-		e.engine.Interrupt <- func() {
-			// nothing to do
+		vm.Interrupt <- func() {
+			panic(errHalt)
 		}
-		res.Err = fmt.Errorf("execution timeout: %dms", timeout)
+		// the vm may still be in use by the interrupted goroutine
+		pool.Replace()
+		res.Err = engine.ContextError(parent, budget.Timeout)
+		res.Logs = logs.Lines()
 		return res
 	case res := <-ch:
+		checkin(pool, vm)
+		res.Logs = logs.Lines()
 		return res
 	}
 }
+
+// checkin returns a vm which has finished normally to the pool
+func checkin(pool *jsvm.VMPool[*otto.Otto], vm *otto.Otto) {
+	vm.Interrupt = nil
+	pool.Checkin(vm)
+}
@@ -0,0 +1,62 @@
+package javascript
+
+import (
+	"service/internal/engine"
+	"service/internal/engine/jsvm"
+
+	"github.com/robertkrimen/otto"
+)
+
+// compiled library module caches of each pool, keyed by module version
+var moduleCaches = jsvm.NewCaches[*otto.Script]()
+
+func compileModule(vm *otto.Otto, pool *engine.Pool, module *engine.Module) (*otto.Script, error) {
+	return jsvm.Compile(moduleCaches.Get(pool), module.Key(), func() (*otto.Script, error) {
+		return vm.Compile(module.Name, jsvm.WrapModule(module.Code))
+	})
+}
+
+// execute the module, returning its frozen exports
+func execModule(vm *otto.Otto, pool *engine.Pool, module *engine.Module) (otto.Value, error) {
+	script, err := compileModule(vm, pool, module)
+	if err != nil {
+		return otto.UndefinedValue(), err
+	}
+
+	factory, err := vm.Run(script)
+	if err != nil {
+		return otto.UndefinedValue(), err
+	}
+
+	moduleObj, err := vm.Object(`({exports: {}})`)
+	if err != nil {
+		return otto.UndefinedValue(), err
+	}
+
+	exports, _ := moduleObj.Get("exports")
+	require, _ := vm.Get("require")
+
+	if _, err := factory.Call(otto.UndefinedValue(), moduleObj, exports, require); err != nil {
+		return otto.UndefinedValue(), err
+	}
+
+	exports, _ = moduleObj.Get("exports")
+	return vm.Call("Object.freeze", nil, exports)
+}
+
+// newRequire returns the `require` function of a run, throwing an Error
+// if the module cannot be loaded
+func newRequire(vm *otto.Otto, pool *engine.Pool, modules engine.ModuleLoader) func(otto.FunctionCall) otto.Value {
+	require := jsvm.NewRequire(Engine{}.Name(), modules, func(module *engine.Module) (otto.Value, error) {
+		return execModule(vm, pool, module)
+	})
+
+	return func(call otto.FunctionCall) otto.Value {
+		exports, err := require(call.Argument(0).String())
+		if err != nil {
+			panic(vm.MakeCustomError("Error", err.Error()))
+		}
+
+		return exports
+	}
+}
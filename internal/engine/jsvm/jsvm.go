@@ -0,0 +1,130 @@
+// Package jsvm holds the pieces shared by the JavaScript engines, i.e. the
+// program run by each vm on creation, the wrapping of the user's code and
+// library modules, the vm pools, the compiled program caches and `require`.
+package jsvm
+
+import (
+	"fmt"
+	"service/internal/engine"
+	"service/internal/engine/cache"
+	"strings"
+)
+
+// Prelude is run by each vm on creation. Console output is forwarded to
+// __print, and `run` calls the runner with the params and meta. Both are
+// set before each execution.
+const Prelude = `
+var console = (function () {
+    function format(args) {
+        var strs = []
+        for (var i = 0; i < args.length; i++) {
+            var arg = args[i]
+            if (typeof arg === "object" && arg !== null) {
+                try {
+                    arg = JSON.stringify(arg)
+                } catch (e) {}
+            }
+            strs.push(String(arg))
+        }
+        return strs.join(" ")
+    }
+
+    function print() {
+        __print(format(arguments))
+    }
+
+    return {
+        log: print,
+        info: print,
+        warn: print,
+        error: print,
+        debug: print
+    }
+})()
+
+function run(runner, p, meta) {
+    p = JSON.parse(p)
+    meta = JSON.parse(meta)
+
+    var ret = runner(p, meta)
+    var json = JSON.stringify(ret)
+
+    if (typeof ret === "object") {
+        ret = json
+    }
+
+    // [legacy string representation, JSON], which is undefined if the
+    // value is not representable in JSON, e.g. undefined or a function
+    return [String(ret), json]
+}
+`
+
+// user code is compiled into a function expression, which is
+// passed to `run` after evaluation
+const runnerHeader = "(function runner (p, meta) { \"use strict\";\n"
+const runnerFooter = "\n})"
+
+// HeaderLines is the number of lines before the user's code once wrapped
+var HeaderLines = strings.Count(runnerHeader, "\n")
+
+// FileName is the file name of the wrapped code, and RunnerName the name
+// of the frame of the runner function in stack traces
+const (
+	FileName   = "runner"
+	RunnerName = "runner"
+)
+
+// library modules are CommonJS modules, compiled into a function expression
+// called with the module object
+const moduleHeader = "(function (module, exports, require) { \"use strict\";\n"
+const moduleFooter = "\n})"
+
+// WrapCode wraps the user's code into the runner function.
+func WrapCode(code string) string {
+	return runnerHeader + code + runnerFooter
+}
+
+// WrapModule wraps the code of a library module into its factory function.
+func WrapModule(code string) string {
+	return moduleHeader + code + moduleFooter
+}
+
+// NewCaches creates the compiled program caches of each pool.
+func NewCaches[T any]() *engine.PoolLocal[*cache.LRU[T]] {
+	return engine.NewPoolLocal(func(pool *engine.Pool) *cache.LRU[T] {
+		return cache.New[T](
+			pool.GetInt("code-cache-size"),
+			pool.GetDuration("code-cache-expiration"),
+		)
+	})
+}
+
+// Compile gets the program of the key from caches, or compiles and caches
+// it if missing.
+func Compile[T any](caches *cache.LRU[T], key string, compile func() (T, error)) (T, error) {
+	if prg, cacheExist := caches.Get(key); cacheExist {
+		return prg, nil
+	}
+
+	prg, err := compile()
+	if err != nil {
+		return prg, err
+	}
+
+	caches.Add(key, prg)
+	return prg, nil
+}
+
+// NewRequire returns the loader of `require` of a run, executing each
+// module once with exec. Errors are to be thrown as an Error by the engine.
+func NewRequire[T any](lang string, modules engine.ModuleLoader, exec func(module *engine.Module) (T, error)) func(name string) (T, error) {
+	loads := engine.NewLoads[T](lang, modules)
+
+	return func(name string) (T, error) {
+		exports, err := loads.Load(name, exec)
+		if err != nil {
+			return exports, fmt.Errorf("cannot load %s: %w", name, err)
+		}
+		return exports, nil
+	}
+}
@@ -0,0 +1,90 @@
+package jsvm_test
+
+import (
+	"context"
+	"errors"
+	"service/internal/engine"
+	"service/internal/engine/cache"
+	"service/internal/engine/jsvm"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVMPool(t *testing.T) {
+	created := 0
+	pool := jsvm.NewVMPool(1, func() int {
+		created++
+		return created
+	})
+
+	vm, err := pool.Checkout(context.Background(), time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, vm)
+
+	_, err = pool.Checkout(context.Background(), time.Millisecond)
+	assert.ErrorIs(t, err, jsvm.ErrBusy)
+	assert.ErrorIs(t, err, engine.ErrOverloaded)
+
+	// the dropped vm is replaced by a new one
+	pool.Replace()
+	vm, err = pool.Checkout(context.Background(), time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, vm)
+	pool.Checkin(vm)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pool.Checkout(ctx, time.Millisecond)
+	assert.ErrorIs(t, err, engine.ErrCancelled)
+}
+
+func TestCompile(t *testing.T) {
+	caches := cache.New[string](0, 0)
+	compiled := 0
+	compile := func() (string, error) {
+		compiled++
+		return "program", nil
+	}
+
+	for i := 0; i < 2; i++ {
+		prg, err := jsvm.Compile(caches, "key", compile)
+		assert.NoError(t, err)
+		assert.Equal(t, "program", prg)
+	}
+	assert.Equal(t, 1, compiled, "cached program compiled again")
+
+	_, err := jsvm.Compile(caches, "bad", func() (string, error) {
+		return "", errors.New("syntax error")
+	})
+	assert.Error(t, err)
+	_, cached := caches.Get("bad")
+	assert.False(t, cached, "failed compilation cached")
+}
+
+func TestRequire(t *testing.T) {
+	loader := func(lang, name string) (*engine.Module, error) {
+		if name != "lib/base" {
+			return nil, engine.ErrUnknownModule
+		}
+		return &engine.Module{Name: name, Version: 1, Code: "base"}, nil
+	}
+
+	executed := 0
+	require := jsvm.NewRequire("javascript", loader, func(module *engine.Module) (string, error) {
+		executed++
+		return module.Code, nil
+	})
+
+	for i := 0; i < 2; i++ {
+		exports, err := require("lib/base")
+		assert.NoError(t, err)
+		assert.Equal(t, "base", exports)
+	}
+	assert.Equal(t, 1, executed, "module executed more than once in a run")
+
+	_, err := require("lib/none")
+	assert.ErrorIs(t, err, engine.ErrUnknownModule)
+	assert.ErrorContains(t, err, "cannot load lib/none")
+}
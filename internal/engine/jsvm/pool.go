@@ -0,0 +1,86 @@
+package jsvm
+
+import (
+	"context"
+	"fmt"
+	"service/internal/engine"
+	"time"
+)
+
+// default number of concurrent runners
+const defaultRunnerNum = 20
+
+var ErrBusy = fmt.Errorf("engine busy: %w", engine.ErrOverloaded)
+
+// VMPool holds a fixed number of vms. A vm is checked out for exclusive
+// use and returned after the execution.
+type VMPool[T any] struct {
+	vms    chan T
+	create func() T
+}
+
+// NewVMPool creates a pool of size vms, defaulting to defaultRunnerNum
+func NewVMPool[T any](size int, create func() T) *VMPool[T] {
+	if size <= 0 {
+		size = defaultRunnerNum
+	}
+
+	p := &VMPool[T]{
+		vms:    make(chan T, size),
+		create: create,
+	}
+
+	for i := 0; i < size; i++ {
+		p.vms <- create()
+	}
+
+	return p
+}
+
+// WaitTimeout returns how long to wait for an idle vm, which is at most
+// the execution timeout if not configured explicitly
+func WaitTimeout(pool *engine.Pool, budget engine.Budget) time.Duration {
+	waitTimeout := pool.GetDuration("runner-wait-timeout") * time.Millisecond
+	if waitTimeout <= 0 {
+		waitTimeout = budget.Timeout
+	}
+	return waitTimeout
+}
+
+// Checkout waits at most timeout for an idle vm, or until ctx is done
+func (p *VMPool[T]) Checkout(ctx context.Context, timeout time.Duration) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, fmt.Errorf("%w: %v", engine.ErrCancelled, err)
+	}
+
+	// fast path without allocating a timer
+	select {
+	case vm := <-p.vms:
+		return vm, nil
+	default:
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case vm := <-p.vms:
+		return vm, nil
+	case <-timer.C:
+		return zero, fmt.Errorf("%w: no idle runner in %v", ErrBusy, timeout)
+	case <-ctx.Done():
+		return zero, fmt.Errorf("%w: %v", engine.ErrCancelled, ctx.Err())
+	}
+}
+
+// Checkin returns a vm which has finished to the pool
+func (p *VMPool[T]) Checkin(vm T) {
+	p.vms <- vm
+}
+
+// Replace drops a checked out vm which cannot be reused, e.g. still in use
+// by an interrupted execution, and fills the pool with a new one
+func (p *VMPool[T]) Replace() {
+	p.vms <- p.create()
+}
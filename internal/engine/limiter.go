@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// default max number of concurrent executions
+const defaultMaxInFlight = 100
+
+var ErrOverloaded = errors.New("too many executions in flight")
+
+type LimiterStats struct {
+	MaxInFlight int    `json:"max_in_flight"`
+	InFlight    int    `json:"in_flight"`
+	Queued      int64  `json:"queued"`
+	Admitted    uint64 `json:"admitted"`
+	Rejected    uint64 `json:"rejected"`
+}
+
+// Limiter bounds the number of concurrent executions. Executions over
+// the limit wait in queue for at most the queue timeout.
+type Limiter struct {
+	slots        chan struct{}
+	queueTimeout time.Duration
+
+	queued   int64
+	admitted uint64
+	rejected uint64
+}
+
+func NewLimiter(maxInFlight int, queueTimeout time.Duration) *Limiter {
+	if maxInFlight <= 0 {
+		maxInFlight = defaultMaxInFlight
+	}
+
+	return &Limiter{
+		slots:        make(chan struct{}, maxInFlight),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// Acquire waits for a free slot, returning a function to release it.
+func (l *Limiter) Acquire(ctx context.Context) (func(), error) {
+	// fast path without allocating a timer
+	select {
+	case l.slots <- struct{}{}:
+		atomic.AddUint64(&l.admitted, 1)
+		return l.release, nil
+	default:
+	}
+
+	atomic.AddInt64(&l.queued, 1)
+	defer atomic.AddInt64(&l.queued, -1)
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		atomic.AddUint64(&l.admitted, 1)
+		return l.release, nil
+	case <-timer.C:
+		atomic.AddUint64(&l.rejected, 1)
+		return nil, fmt.Errorf("%w: no free slot in %v", ErrOverloaded, l.queueTimeout)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %v", ErrCancelled, ctx.Err())
+	}
+}
+
+func (l *Limiter) release() {
+	<-l.slots
+}
+
+func (l *Limiter) Stats() LimiterStats {
+	return LimiterStats{
+		MaxInFlight: cap(l.slots),
+		InFlight:    len(l.slots),
+		Queued:      atomic.LoadInt64(&l.queued),
+		Admitted:    atomic.LoadUint64(&l.admitted),
+		Rejected:    atomic.LoadUint64(&l.rejected),
+	}
+}
@@ -0,0 +1,47 @@
+package engine_test
+
+import (
+	"context"
+	"service/internal/engine"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	limiter := engine.NewLimiter(1, time.Millisecond)
+
+	release, err := limiter.Acquire(context.Background())
+	assert.NoError(t, err)
+
+	_, err = limiter.Acquire(context.Background())
+	assert.ErrorIs(t, err, engine.ErrOverloaded, "limit not respected")
+
+	stats := limiter.Stats()
+	assert.Equal(t, 1, stats.InFlight)
+	assert.Equal(t, uint64(1), stats.Admitted)
+	assert.Equal(t, uint64(1), stats.Rejected)
+
+	// a queued execution is admitted after release
+	limiter = engine.NewLimiter(1, 50*time.Millisecond)
+	release, _ = limiter.Acquire(context.Background())
+	time.AfterFunc(5*time.Millisecond, release)
+
+	release, err = limiter.Acquire(context.Background())
+	assert.NoError(t, err, "queued execution not admitted")
+	release()
+
+	assert.Equal(t, 0, limiter.Stats().InFlight)
+}
+
+func TestLimiterCancel(t *testing.T) {
+	limiter := engine.NewLimiter(1, time.Second)
+	limiter.Acquire(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := limiter.Acquire(ctx)
+	assert.ErrorIs(t, err, engine.ErrCancelled)
+}
@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// default max total size of captured logs in bytes
+const defaultMaxLogSize = 64 * 1024
+
+// LogBuffer collects the output of print statements. A nil buffer
+// discards all output.
+type LogBuffer struct {
+	mu        sync.Mutex
+	lines     []string
+	size      int
+	limit     int
+	truncated bool
+}
+
+// NewLogBuffer creates a buffer holding at most `max-log-size` bytes,
+// or returns nil if capture is not enabled.
+func NewLogBuffer(capture bool) *LogBuffer {
+	if !capture {
+		return nil
+	}
+
+	limit := viper.GetInt("max-log-size")
+	if limit <= 0 {
+		limit = defaultMaxLogSize
+	}
+
+	return &LogBuffer{limit: limit}
+}
+
+func (b *LogBuffer) Print(line string) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.size+len(line) > b.limit {
+		b.truncated = true
+		return
+	}
+
+	b.size += len(line)
+	b.lines = append(b.lines, line)
+}
+
+// Lines returns the captured lines, ending with a notice if truncated.
+func (b *LogBuffer) Lines() []string {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lines := make([]string, len(b.lines), len(b.lines)+1)
+	copy(lines, b.lines)
+
+	if b.truncated {
+		lines = append(lines, "... (output truncated)")
+	}
+
+	return lines
+}
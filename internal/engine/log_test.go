@@ -0,0 +1,28 @@
+package engine_test
+
+import (
+	"service/internal/engine"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogBuffer(t *testing.T) {
+	viper.Set("max-log-size", 8)
+	defer viper.Set("max-log-size", nil)
+
+	logs := engine.NewLogBuffer(true)
+	logs.Print("1234")
+	logs.Print("5678")
+	logs.Print("9")
+
+	assert.Equal(t, []string{"1234", "5678", "... (output truncated)"}, logs.Lines())
+}
+
+func TestDiscardLogs(t *testing.T) {
+	logs := engine.NewLogBuffer(false)
+	logs.Print("discarded")
+
+	assert.Nil(t, logs.Lines())
+}
@@ -0,0 +1,116 @@
+package lua
+
+import (
+	"math"
+	"regexp"
+	"service/internal/engine"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// file name of the wrapped code
+const fileName = "runner"
+
+// lines of runnerHeader before the user's code
+const headerLines = 1
+
+// name of the frame of the user's top level code
+const mainFrame = "<main>"
+
+// name of the frames of anonymous functions
+const anonymousFrame = "<anonymous>"
+
+// position prefixed to the error messages, e.g. `runner:3: `
+var positionRegexp = regexp.MustCompile(`^` + fileName + `:(\d+): `)
+
+// a frame of the stack trace, e.g. `	runner:3: in function 'f'`
+var frameRegexp = regexp.MustCompile(`^\s*` + fileName + `:(\d+): in (.+)$`)
+
+func toUserPos(code string, line int, column int) (int, int) {
+	return engine.ClampPosition(code, line-headerLines, column)
+}
+
+// name of the function in a frame of the stack trace
+func frameName(desc string) string {
+	switch {
+	case desc == "main chunk":
+		return mainFrame
+	case strings.HasPrefix(desc, "function '"):
+		return strings.TrimSuffix(strings.TrimPrefix(desc, "function '"), "'")
+	}
+	return anonymousFrame
+}
+
+// translate errors from the wrapped code into the user's code
+func translateError(code string, err error) error {
+	switch e := err.(type) {
+	case *parse.Error:
+		line := e.Pos.Line
+		if line == parse.EOF {
+			// clamped to the end of the code
+			line = math.MaxInt32
+		}
+		line, column := toUserPos(code, line, e.Pos.Column)
+		return &engine.ExecError{
+			Kind:    engine.ErrorSyntax,
+			Line:    line,
+			Column:  column,
+			Message: e.Message,
+		}
+	case *lua.CompileError:
+		line, column := toUserPos(code, e.Line, 0)
+		return &engine.ExecError{
+			Kind:    engine.ErrorSyntax,
+			Line:    line,
+			Column:  column,
+			Message: e.Message,
+		}
+	case *lua.ApiError:
+		msg := e.Object.String()
+		execErr := &engine.ExecError{
+			Kind:    engine.ErrorRuntime,
+			Message: positionRegexp.ReplaceAllString(msg, ""),
+		}
+
+		for _, str := range strings.Split(e.StackTrace, "\n") {
+			match := frameRegexp.FindStringSubmatch(str)
+			if match == nil {
+				// native code
+				continue
+			}
+
+			line, _ := strconv.Atoi(match[1])
+			line, column := toUserPos(code, line, 0)
+
+			execErr.Stack = append(execErr.Stack, engine.Frame{
+				Name:   frameName(match[2]),
+				Line:   line,
+				Column: column,
+			})
+		}
+
+		if match := positionRegexp.FindStringSubmatch(msg); match != nil {
+			// the position where the error is raised
+			line, _ := strconv.Atoi(match[1])
+			execErr.Line, execErr.Column = toUserPos(code, line, 0)
+		} else if len(execErr.Stack) > 0 {
+			execErr.Line = execErr.Stack[0].Line
+			execErr.Column = execErr.Stack[0].Column
+		}
+
+		return execErr
+	}
+
+	return err
+}
+
+// translate the error into a list, as the parser stops at the first error
+func translateErrors(code string, err error) []*engine.ExecError {
+	if err == nil {
+		return nil
+	}
+	return []*engine.ExecError{engine.Describe(translateError(code, err))}
+}
@@ -0,0 +1,145 @@
+package lua
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"service/internal/engine"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// maximum nesting of tables encoded into JSON, catching cycles
+const maxEncodeDepth = 100
+
+var errNotSerializable = errors.New("value not serializable")
+
+// decode the JSON into a Lua value. Objects and arrays are decoded into
+// tables, so null members are lost as in Lua.
+func decodeJSON(L *lua.LState, str string) (lua.LValue, error) {
+	var val interface{}
+	if err := json.Unmarshal([]byte(str), &val); err != nil {
+		return nil, &engine.ExecError{
+			Kind:    engine.ErrorRuntime,
+			Message: fmt.Sprintf("fail to decode JSON: %v", err),
+		}
+	}
+	return fromGo(L, val), nil
+}
+
+func fromGo(L *lua.LState, val interface{}) lua.LValue {
+	switch v := val.(type) {
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case []interface{}:
+		table := L.CreateTable(len(v), 0)
+		for _, elem := range v {
+			table.Append(fromGo(L, elem))
+		}
+		return table
+	case map[string]interface{}:
+		table := L.CreateTable(0, len(v))
+		for key, elem := range v {
+			table.RawSetString(key, fromGo(L, elem))
+		}
+		return table
+	}
+	return lua.LNil
+}
+
+// encode the value into JSON, returning nil if not serializable
+func encodeJSON(val lua.LValue) []byte {
+	v, err := toGo(val, 0)
+	if err != nil {
+		return nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// convert the value for JSON encoding. Tables with keys 1..n are
+// encoded as arrays, other tables as objects.
+func toGo(val lua.LValue, depth int) (interface{}, error) {
+	if depth > maxEncodeDepth {
+		return nil, errNotSerializable
+	}
+
+	switch v := val.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case lua.LBool:
+		return bool(v), nil
+	case lua.LNumber:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return nil, errNotSerializable
+		}
+		return float64(v), nil
+	case lua.LString:
+		return string(v), nil
+	case *lua.LTable:
+		return tableToGo(v, depth)
+	}
+	return nil, errNotSerializable
+}
+
+func tableToGo(table *lua.LTable, depth int) (interface{}, error) {
+	var keys []lua.LValue
+	table.ForEach(func(key, _ lua.LValue) {
+		keys = append(keys, key)
+	})
+
+	if len(keys) > 0 && isSequence(keys) {
+		arr := make([]interface{}, len(keys))
+		for i := range arr {
+			elem, err := toGo(table.RawGetInt(i+1), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = elem
+		}
+		return arr, nil
+	}
+
+	obj := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		var name string
+		switch k := key.(type) {
+		case lua.LString:
+			name = string(k)
+		case lua.LNumber:
+			name = k.String()
+		default:
+			return nil, errNotSerializable
+		}
+
+		elem, err := toGo(table.RawGet(key), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		obj[name] = elem
+	}
+	return obj, nil
+}
+
+// whether the keys are exactly 1..n
+func isSequence(keys []lua.LValue) bool {
+	seen := make([]bool, len(keys))
+	for _, key := range keys {
+		n, ok := key.(lua.LNumber)
+		i := int(n)
+		if !ok || float64(i) != float64(n) || i < 1 || i > len(keys) || seen[i-1] {
+			return false
+		}
+		seen[i-1] = true
+	}
+	return true
+}
@@ -0,0 +1,249 @@
+package lua
+
+import (
+	"context"
+	"service/internal/engine"
+	"service/internal/engine/cache"
+	"service/internal/utils"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// user code is compiled as the main chunk, receiving the decoded params
+// and config meta as its arguments
+const runnerHeader = "local p, meta = ...\n"
+
+// compiled code caches of each pool
+var codeCaches = engine.NewPoolLocal(func(pool *engine.Pool) *cache.LRU[*lua.FunctionProto] {
+	return cache.New[*lua.FunctionProto](
+		pool.GetInt("code-cache-size"),
+		pool.GetDuration("code-cache-expiration"),
+	)
+})
+
+// standard libraries opened in each state, without io, os and package
+var libs = []struct {
+	name string
+	open lua.LGFunction
+}{
+	{lua.BaseLibName, lua.OpenBase},
+	{lua.TabLibName, lua.OpenTable},
+	{lua.StringLibName, lua.OpenString},
+	{lua.MathLibName, lua.OpenMath},
+}
+
+// functions of the base library removed from the globals, which access
+// the file system or the process
+var unsafeGlobals = []string{
+	"dofile",
+	"loadfile",
+	"module",
+	"require",
+	"collectgarbage",
+	"_printregs",
+}
+
+type Engine struct{}
+
+func init() {
+	engine.Register(Engine{})
+}
+
+func (Engine) Name() string {
+	return "lua"
+}
+
+func (Engine) Run(ctx context.Context, task engine.Task) engine.RunResult {
+	return Run(ctx, task)
+}
+
+func (Engine) Validate(pool *engine.Pool, code string) []*engine.ExecError {
+	return Validate(code)
+}
+
+func (Engine) MetaFields(code string) ([]string, bool) {
+	return engine.ScanMetaFields(code)
+}
+
+func (Engine) ClearCache(code string) {
+	ClearCache(code)
+}
+
+func (Engine) CacheStats(pool *engine.Pool) cache.Stats {
+	return CacheStats(pool)
+}
+
+// version of the interpreter, part of the compiled code cache keys
+var version = engine.ModuleVersion("github.com/yuin/gopher-lua")
+
+func cacheKey(code string) string {
+	return engine.CacheKey(Engine{}.Name(), version, code)
+}
+
+func ClearCache(code string) {
+	key := cacheKey(code)
+	for _, caches := range codeCaches.All() {
+		caches.Remove(key)
+	}
+}
+
+func CacheStats(pool *engine.Pool) cache.Stats {
+	return codeCaches.Get(pool).Stats()
+}
+
+func wrapCode(code string) string {
+	return runnerHeader + code
+}
+
+func parseAndCompile(code string) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(wrapCode(code)), fileName)
+	if err != nil {
+		return nil, err
+	}
+	return lua.Compile(chunk, fileName)
+}
+
+// Validate parses and compiles the code, returning the first error found.
+func Validate(code string) []*engine.ExecError {
+	_, err := parseAndCompile(code)
+	return translateErrors(code, err)
+}
+
+// compile the code, or get the compiled chunk from cache
+func compile(pool *engine.Pool, code string) (*lua.FunctionProto, error) {
+	caches := codeCaches.Get(pool)
+	key := cacheKey(code)
+	if proto, cacheExist := caches.Get(key); cacheExist {
+		return proto, nil
+	}
+
+	proto, err := parseAndCompile(code)
+	if err != nil {
+		return nil, err
+	}
+
+	caches.Add(key, proto)
+	return proto, nil
+}
+
+// newState creates a state with the safe standard libraries, printing
+// into logs
+func newState(logs *engine.LogBuffer) *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+
+	for _, lib := range libs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	for _, name := range unsafeGlobals {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	L.SetGlobal("print", L.NewFunction(func(L *lua.LState) int {
+		args := make([]string, L.GetTop())
+		for i := range args {
+			args[i] = L.ToStringMeta(L.Get(i + 1)).String()
+		}
+		logs.Print(strings.Join(args, "\t"))
+		return 0
+	}))
+	L.SetGlobal("bucket", L.NewFunction(bucket))
+
+	return L
+}
+
+// bucket(key, salt, n) maps the salted key into [0, n) with the same
+// hashing as the gray release of the service
+func bucket(L *lua.LState) int {
+	key := L.CheckString(1)
+	salt := L.CheckString(2)
+	bucket, err := utils.Bucket(key, salt, L.CheckInt64(3))
+	if err != nil {
+		L.ArgError(3, err.Error())
+	}
+
+	L.Push(lua.LNumber(bucket))
+	return 1
+}
+
+func Run(parent context.Context, task engine.Task) engine.RunResult {
+	budget := task.Budget.Effective(task.Pool)
+
+	proto, err := compile(task.Pool, task.Code)
+	if err != nil {
+		return engine.RunResult{Err: translateError(task.Code, err)}
+	}
+
+	logs := engine.NewLogBuffer(task.CaptureLogs)
+
+	ctx, cancel := context.WithTimeout(parent, budget.Timeout)
+	defer cancel()
+
+	// result channel
+	ch := make(chan engine.RunResult, 1)
+
+	go func() {
+		res := engine.RunResult{}
+
+		L := newState(logs)
+		defer L.Close()
+		L.SetContext(ctx)
+
+		p, err := decodeJSON(L, task.Params)
+		if err != nil {
+			res.Err = err
+			ch <- res
+			return
+		}
+		meta, err := decodeJSON(L, task.MetaJSON())
+		if err != nil {
+			res.Err = err
+			ch <- res
+			return
+		}
+
+		err = L.CallByParam(lua.P{
+			Fn:      L.NewFunctionFromProto(proto),
+			NRet:    1,
+			Protect: true,
+		}, p, meta)
+
+		if err != nil {
+			if ctx.Err() != nil {
+				res.Err = engine.ContextError(parent, budget.Timeout)
+			} else {
+				res.Err = translateError(task.Code, err)
+			}
+			ch <- res
+			return
+		}
+
+		val := L.Get(-1)
+		L.Pop(1)
+
+		res.JSON = encodeJSON(val)
+		if _, isTable := val.(*lua.LTable); isTable && res.JSON != nil {
+			res.Val = string(res.JSON)
+		} else {
+			res.Val = val.String()
+		}
+
+		ch <- res
+	}()
+
+	var res engine.RunResult
+
+	select {
+	case <-ctx.Done():
+		res.Err = engine.ContextError(parent, budget.Timeout)
+		res.Logs = logs.Lines()
+		return res
+	case res := <-ch:
+		res.Logs = logs.Lines()
+		return res
+	}
+}
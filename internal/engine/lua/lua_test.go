@@ -0,0 +1,228 @@
+package lua_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"service/internal/engine"
+	"service/internal/engine/lua"
+	"service/internal/utils"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+var ctx = context.Background()
+
+func TestMain(m *testing.M) {
+	viper.SetDefault("timeout", 50)
+
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestParams(t *testing.T) {
+	code := `return p.version_code`
+	params := `{"version_code": 1024}`
+	res := lua.Run(ctx, engine.Task{Code: code, Params: params})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "1024", res.Val, "wrong returned result")
+}
+
+func TestInlineFunc(t *testing.T) {
+	code := `
+local function judge(version)
+	if version > 100 then
+		return "valid"
+	end
+	return "invalid"
+end
+
+return judge(p["version_code"])
+`
+	params := `{"version_code": 1024}`
+	res := lua.Run(ctx, engine.Task{Code: code, Params: params})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "valid", res.Val, "wrong returned result")
+}
+
+func TestTimeout(t *testing.T) {
+	res := lua.Run(ctx, engine.Task{Code: "while true do end", Params: "{}"})
+	assert.ErrorIs(t, res.Err, engine.ErrBudgetExceeded, "fail to exit on timeout")
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(5*time.Millisecond, cancel)
+
+	res := lua.Run(ctx, engine.Task{Code: "while true do end", Params: "{}"})
+	assert.ErrorIs(t, res.Err, engine.ErrCancelled, "fail to exit on cancellation")
+}
+
+func TestJSONResult(t *testing.T) {
+	cases := map[string]string{
+		`return "valid"`:                     `"valid"`,
+		`return true`:                        `true`,
+		`return p.version_code`:              `1024`,
+		`return {arr = {1, 2.5}}`:            `{"arr":[1,2.5]}`,
+		``:                                   `null`,
+		`return {"a", false, 0}`:             `["a",false,0]`,
+		`return {nested = {val = "s"}}`:      `{"nested":{"val":"s"}}`,
+		`return {[1] = "a", [3] = "c"}`:      `{"1":"a","3":"c"}`,
+		`return p.list`:                      `[1,"b"]`,
+		`return {[1] = "a", [2] = "b"}`:      `["a","b"]`,
+		`return {p.version_code, #p.list}`:   `[1024,2]`,
+		`return string.format("%d", 1) .. 2`: `"12"`,
+	}
+
+	for code, expected := range cases {
+		res := lua.Run(ctx, engine.Task{Code: code, Params: `{"version_code": 1024, "list": [1, "b"]}`})
+		assert.NoError(t, res.Err, "runner returned an error")
+		assert.JSONEq(t, expected, string(res.JSON), "wrong JSON result for %s", code)
+	}
+
+	// not serializable
+	res := lua.Run(ctx, engine.Task{Code: "local t = {}\nt.t = t\nreturn t", Params: "{}"})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Nil(t, res.JSON, "cyclic table encoded")
+
+	res = lua.Run(ctx, engine.Task{Code: "return print", Params: "{}"})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Nil(t, res.JSON, "function encoded")
+
+	// legacy string representation
+	res = lua.Run(ctx, engine.Task{Code: "", Params: "{}"})
+	assert.Equal(t, "nil", res.Val, "wrong returned result")
+
+	res = lua.Run(ctx, engine.Task{Code: `return {a = 1}`, Params: "{}"})
+	assert.Equal(t, `{"a":1}`, res.Val, "wrong returned result")
+}
+
+func TestCaptureLogs(t *testing.T) {
+	code := `
+print("version", p.version_code)
+return 1
+`
+	res := lua.Run(ctx, engine.Task{Code: code, Params: `{"version_code": 1024}`, CaptureLogs: true})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, []string{"version\t1024"}, res.Logs, "wrong logs")
+
+	res = lua.Run(ctx, engine.Task{Code: code, Params: `{"version_code": 1024}`})
+	assert.Nil(t, res.Logs, "logs captured without request")
+}
+
+func TestRestrictedLibs(t *testing.T) {
+	for _, name := range []string{"io", "os", "package", "require", "dofile", "loadfile", "collectgarbage"} {
+		res := lua.Run(ctx, engine.Task{Code: "return " + name + " == nil", Params: "{}"})
+		assert.NoError(t, res.Err, "runner returned an error")
+		assert.Equal(t, "true", res.Val, "%s is available", name)
+	}
+
+	res := lua.Run(ctx, engine.Task{Code: `return math.floor(string.len("abc") / 2) + #table.concat({"a", "b"})`, Params: "{}"})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "3", res.Val, "wrong returned result")
+}
+
+func TestMetaAndBucket(t *testing.T) {
+	code := `return {meta.device_id, bucket(meta.device_id, "exp", 100)}`
+	meta := `{"platform": "ios", "version": 3, "device_id": "device"}`
+	res := lua.Run(ctx, engine.Task{Code: code, Params: "{}", Meta: meta})
+	assert.NoError(t, res.Err, "runner returned an error")
+	bucket, _ := utils.Bucket("device", "exp", 100)
+	assert.JSONEq(t, fmt.Sprintf(`["device", %d]`, bucket), string(res.JSON))
+
+	res = lua.Run(ctx, engine.Task{Code: `return next(meta) == nil`, Params: "{}"})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "true", res.Val, "meta not defaulted")
+
+	res = lua.Run(ctx, engine.Task{Code: `return bucket("a", "b", 0)`, Params: "{}"})
+	assert.ErrorContains(t, res.Err, "n must be a positive integer")
+
+	// out of uint32, not wrapped to 0 buckets
+	res = lua.Run(ctx, engine.Task{Code: `return bucket("a", "b", 2 ^ 32)`, Params: "{}"})
+	assert.ErrorContains(t, res.Err, "n must be a positive integer")
+}
+
+func TestErrorPosition(t *testing.T) {
+	cases := []struct {
+		code   string
+		kind   string
+		line   int
+		column int
+	}{
+		{"local x = 1\nreturn (", engine.ErrorSyntax, 2, 9},
+		{"local x = 1\nreturn x.y", engine.ErrorRuntime, 2, 1},
+		{"local function f(a)\n  return a.b.c\nend\nreturn f({})", engine.ErrorRuntime, 2, 1},
+		{`error("failed")`, engine.ErrorRuntime, 1, 1},
+	}
+
+	for _, c := range cases {
+		res := lua.Run(ctx, engine.Task{Code: c.code, Params: "{}"})
+		err := engine.Describe(res.Err)
+		assert.Equal(t, c.kind, err.Kind, "wrong error kind for %s", c.code)
+		assert.Equal(t, c.line, err.Line, "wrong error line for %s", c.code)
+		assert.Equal(t, c.column, err.Column, "wrong error column for %s", c.code)
+	}
+
+	res := lua.Run(ctx, engine.Task{Code: `error("failed")`, Params: "{}"})
+	assert.Equal(t, "failed", engine.Describe(res.Err).Message, "wrong error message")
+
+	res = lua.Run(ctx, engine.Task{Code: "local function f(a)\n  return a.b.c\nend\nlocal ret = f({})\nreturn ret", Params: "{}"})
+	assert.Equal(t, []engine.Frame{
+		{Name: "f", Line: 2, Column: 1},
+		{Name: "<main>", Line: 4, Column: 1},
+	}, engine.Describe(res.Err).Stack, "wrong call stack")
+}
+
+func TestValidate(t *testing.T) {
+	assert.Empty(t, lua.Validate(`return p.version_code`))
+	assert.NotEmpty(t, lua.Validate(`return (`), "syntax error not reported")
+	assert.NotEmpty(t, lua.Validate("return 1\nlocal x = 2"), "statement after return not reported")
+}
+
+func TestHitCodeCache(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		res := lua.Run(ctx, engine.Task{Code: "return 1", Params: "{}"})
+		assert.NoError(t, res.Err, "runner returned an error")
+	}
+
+	lua.ClearCache("return 1")
+}
+
+func TestConcurrentCodeCacheAccess(t *testing.T) {
+	const num = 1000
+	ch := make(chan int, num)
+	for i := 0; i < num; i++ {
+		go func(i int) {
+			code := fmt.Sprintf("return %d", i)
+			lua.Run(ctx, engine.Task{Code: code, Params: "{}"})
+			lua.ClearCache(code)
+			ch <- 1
+		}(i)
+	}
+
+	resultNum := 0
+
+	for range ch {
+		resultNum++
+		if resultNum == num {
+			break
+		}
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	before := lua.CacheStats(nil)
+
+	for i := 0; i < 2; i++ {
+		res := lua.Run(ctx, engine.Task{Code: "return 'stats'", Params: "{}"})
+		assert.NoError(t, res.Err, "runner returned an error")
+	}
+	lua.ClearCache("return 'stats'")
+
+	after := lua.CacheStats(nil)
+	assert.Equal(t, before.Hits+1, after.Hits, "wrong number of hits")
+	assert.Equal(t, before.Misses+1, after.Misses, "wrong number of misses")
+}
@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"regexp"
+	"sort"
+)
+
+var (
+	metaRegexp = regexp.MustCompile(`\bmeta\b`)
+
+	// accesses of a field of meta following the name
+	metaGetRegexp   = regexp.MustCompile(`^\.get\(\s*(?:"([^"\\]*)"|'([^'\\]*)')`)
+	metaAttrRegexp  = regexp.MustCompile(`^\.([A-Za-z_]\w*)\s*(\()?`)
+	metaIndexRegexp = regexp.MustCompile(`^\[\s*(?:"([^"\\]*)"|'([^'\\]*)')\s*\]`)
+)
+
+// methods of dicts, whose names are not field accesses
+var dictMethods = map[string]bool{
+	"get": true, "keys": true, "values": true, "items": true,
+}
+
+// ScanMetaFields returns the fields of `meta` read by the code, found as
+// `meta.field`, `meta["field"]` or `meta.get("field")`. It returns false
+// if meta is used in any other way, e.g. passed to a function or indexed
+// by a variable, in which case the whole meta may be read.
+func ScanMetaFields(code string) ([]string, bool) {
+	seen := make(map[string]bool)
+	for _, loc := range metaRegexp.FindAllStringIndex(code, -1) {
+		// attributes of other objects, e.g. `p.meta`
+		if loc[0] > 0 && (code[loc[0]-1] == '.' || code[loc[0]-1] == '$') {
+			continue
+		}
+
+		rest := code[loc[1]:]
+		if match := metaGetRegexp.FindStringSubmatch(rest); match != nil {
+			seen[match[1]+match[2]] = true
+		} else if match := metaIndexRegexp.FindStringSubmatch(rest); match != nil {
+			seen[match[1]+match[2]] = true
+		} else if match := metaAttrRegexp.FindStringSubmatch(rest); match != nil &&
+			match[2] == "" && !dictMethods[match[1]] {
+			seen[match[1]] = true
+		} else {
+			return nil, false
+		}
+	}
+
+	fields := make([]string, 0, len(seen))
+	for field := range seen {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields, true
+}
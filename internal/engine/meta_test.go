@@ -0,0 +1,35 @@
+package engine_test
+
+import (
+	"service/internal/engine"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanMetaFields(t *testing.T) {
+	cases := map[string][]string{
+		`return p.version`: {},
+		`return meta.version > 2 && meta["platform"] == "ios"`:           {"platform", "version"},
+		`return bucket(meta.get('device_id'), "exp", 10) + meta.version`: {"device_id", "version"},
+		`return p.meta.x + metadata.y`:                                   {},
+	}
+	for code, expected := range cases {
+		fields, ok := engine.ScanMetaFields(code)
+		assert.True(t, ok, "fields not found in %s", code)
+		assert.Equal(t, expected, fields, "wrong fields of %s", code)
+	}
+
+	// the whole meta may be read
+	for _, code := range []string{
+		`return f(meta)`,
+		`return meta[p.key]`,
+		`return meta.get(p.key)`,
+		`return meta.keys()`,
+		`var get = meta.get`,
+		`return meta.hasOwnProperty(p.key)`,
+	} {
+		_, ok := engine.ScanMetaFields(code)
+		assert.False(t, ok, "whole meta not reported for %s", code)
+	}
+}
@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrUnknownModule = errors.New("unknown module")
+	ErrCircularLoad  = errors.New("circular module load")
+)
+
+// Module is a shared library module loadable from the code.
+type Module struct {
+	// language the module is published for, which may differ from the
+	// language of the code loading it
+	Lang    string
+	Name    string
+	Version int
+	Code    string
+}
+
+// Key identifies the module version, used as the compiled code cache id.
+func (m *Module) Key() string {
+	return fmt.Sprintf("%s@%d", m.Name, m.Version)
+}
+
+// ModuleLoader returns the module of the language with the name, which
+// may be suffixed with `@<version>` to pin a version. The latest version
+// is returned otherwise.
+type ModuleLoader func(lang, name string) (*Module, error)
+
+type loaded[T any] struct {
+	value T
+	err   error
+	done  bool
+}
+
+// Loads tracks the modules loaded during a single run, so that each module
+// is executed at most once per run and circular loads are reported.
+type Loads[T any] struct {
+	lang   string
+	loader ModuleLoader
+	loaded map[string]*loaded[T]
+	stack  []string // modules being loaded
+}
+
+func NewLoads[T any](lang string, loader ModuleLoader) *Loads[T] {
+	return &Loads[T]{
+		lang:   lang,
+		loader: loader,
+		loaded: make(map[string]*loaded[T]),
+	}
+}
+
+// Load returns the value of the module with the name, executing the
+// module with exec on the first load.
+func (l *Loads[T]) Load(name string, exec func(module *Module) (T, error)) (T, error) {
+	if entry, exist := l.loaded[name]; exist {
+		if !entry.done {
+			cycle := strings.Join(append(l.stack, name), " -> ")
+			return entry.value, fmt.Errorf("%w: %s", ErrCircularLoad, cycle)
+		}
+		return entry.value, entry.err
+	}
+
+	entry := &loaded[T]{}
+	l.loaded[name] = entry
+
+	if l.loader == nil {
+		entry.err = fmt.Errorf("%w '%s'", ErrUnknownModule, name)
+	} else if module, err := l.loader(l.lang, name); err != nil {
+		entry.err = err
+	} else {
+		l.stack = append(l.stack, name)
+		entry.value, entry.err = exec(module)
+		l.stack = l.stack[:len(l.stack)-1]
+	}
+
+	entry.done = true
+	return entry.value, entry.err
+}
@@ -0,0 +1,49 @@
+package engine_test
+
+import (
+	"service/internal/engine"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoads(t *testing.T) {
+	modules := map[string]string{"a": "b", "b": "a", "c": ""}
+	loaded := 0
+
+	loads := engine.NewLoads[string]("echo", func(lang, name string) (*engine.Module, error) {
+		code, exist := modules[name]
+		if !exist {
+			return nil, engine.ErrUnknownModule
+		}
+		return &engine.Module{Name: name, Code: code}, nil
+	})
+
+	var load func(module *engine.Module) (string, error)
+	load = func(module *engine.Module) (string, error) {
+		loaded++
+		if module.Code != "" {
+			return loads.Load(module.Code, load)
+		}
+		return module.Name, nil
+	}
+
+	val, err := loads.Load("c", load)
+	assert.NoError(t, err)
+	assert.Equal(t, "c", val)
+
+	val, err = loads.Load("c", load)
+	assert.NoError(t, err)
+	assert.Equal(t, "c", val)
+	assert.Equal(t, 1, loaded, "module executed more than once")
+
+	_, err = loads.Load("a", load)
+	assert.ErrorIs(t, err, engine.ErrCircularLoad)
+	assert.Contains(t, err.Error(), "a -> b -> a")
+
+	_, err = loads.Load("d", load)
+	assert.ErrorIs(t, err, engine.ErrUnknownModule)
+
+	_, err = engine.NewLoads[string]("echo", nil).Load("c", load)
+	assert.ErrorIs(t, err, engine.ErrUnknownModule, "module loaded without a loader")
+}
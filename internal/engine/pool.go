@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// names of the execution pools
+const (
+	PoolDefault    = "default"
+	PoolConfig     = "config"
+	PoolTest       = "test"
+	PoolPlayground = "playground"
+)
+
+// Pool is a named execution capacity with its own concurrency limit,
+// timeout and caches. Settings are read from `pools.<name>.<key>`,
+// falling back to the global `<key>`. A nil pool is the default pool.
+type Pool struct {
+	name    string
+	limiter *Limiter
+}
+
+var (
+	pools     = make(map[string]*Pool)
+	poolsLock sync.Mutex
+)
+
+// GetPool returns the pool with name, created on first use after the
+// configuration is loaded.
+func GetPool(name string) *Pool {
+	poolsLock.Lock()
+	defer poolsLock.Unlock()
+
+	if pool, exist := pools[name]; exist {
+		return pool
+	}
+
+	pool := &Pool{name: name}
+
+	// the queue timeout defaults to the execution timeout
+	queueTimeout := pool.GetDuration("queue-timeout")
+	if queueTimeout <= 0 {
+		queueTimeout = pool.GetDuration("timeout")
+	}
+	pool.limiter = NewLimiter(pool.GetInt("max-in-flight"), queueTimeout*time.Millisecond)
+
+	pools[name] = pool
+	return pool
+}
+
+// Pools returns all created pools ordered by name.
+func Pools() []*Pool {
+	poolsLock.Lock()
+	defer poolsLock.Unlock()
+
+	ret := make([]*Pool, 0, len(pools))
+	for _, pool := range pools {
+		ret = append(ret, pool)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].name < ret[j].name
+	})
+
+	return ret
+}
+
+func (p *Pool) Name() string {
+	if p == nil {
+		return PoolDefault
+	}
+	return p.name
+}
+
+func (p *Pool) key(key string) string {
+	if poolKey := "pools." + p.Name() + "." + key; viper.IsSet(poolKey) {
+		return poolKey
+	}
+	return key
+}
+
+func (p *Pool) IsSet(key string) bool {
+	return viper.IsSet(p.key(key))
+}
+
+func (p *Pool) GetBool(key string) bool {
+	return viper.GetBool(p.key(key))
+}
+
+func (p *Pool) GetInt(key string) int {
+	return viper.GetInt(p.key(key))
+}
+
+func (p *Pool) GetUint64(key string) uint64 {
+	return viper.GetUint64(p.key(key))
+}
+
+func (p *Pool) GetDuration(key string) time.Duration {
+	return viper.GetDuration(p.key(key))
+}
+
+func (p *Pool) GetStringSlice(key string) []string {
+	return viper.GetStringSlice(p.key(key))
+}
+
+func (p *Pool) Limiter() *Limiter {
+	if p == nil {
+		return GetPool(PoolDefault).limiter
+	}
+	return p.limiter
+}
+
+// Run executes the task with e in the pool, waiting for admission. The
+// task is executed out of process if `sandbox` is set for the pool.
+func (p *Pool) Run(ctx context.Context, e Engine, task Task) RunResult {
+	release, err := p.Limiter().Acquire(ctx)
+	if err != nil {
+		return RunResult{Err: err}
+	}
+	defer release()
+
+	task.Pool = p
+
+	if p.GetBool("sandbox") {
+		if sandbox == nil {
+			return RunResult{Err: ErrNoSandbox}
+		}
+		return sandbox.Run(ctx, e, task)
+	}
+
+	return e.Run(ctx, task)
+}
+
+// PoolLocal holds a value for each pool, created on first use.
+type PoolLocal[T any] struct {
+	mu     sync.Mutex
+	values map[string]T
+	create func(pool *Pool) T
+}
+
+func NewPoolLocal[T any](create func(pool *Pool) T) *PoolLocal[T] {
+	return &PoolLocal[T]{
+		values: make(map[string]T),
+		create: create,
+	}
+}
+
+func (l *PoolLocal[T]) Get(pool *Pool) T {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	name := pool.Name()
+	if val, exist := l.values[name]; exist {
+		return val
+	}
+
+	val := l.create(pool)
+	l.values[name] = val
+	return val
+}
+
+// All returns the values of all pools created so far.
+func (l *PoolLocal[T]) All() []T {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ret := make([]T, 0, len(l.values))
+	for _, val := range l.values {
+		ret = append(ret, val)
+	}
+	return ret
+}
@@ -0,0 +1,84 @@
+package engine_test
+
+import (
+	"context"
+	"service/internal/engine"
+	"service/internal/engine/cache"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolSettings(t *testing.T) {
+	viper.Set("timeout", 50)
+	viper.Set("pools.heavy.timeout", 200)
+	defer viper.Set("timeout", nil)
+	defer viper.Set("pools.heavy.timeout", nil)
+
+	heavy := engine.GetPool("heavy")
+	assert.Equal(t, "heavy", heavy.Name())
+	assert.Equal(t, 200*time.Millisecond, engine.Budget{}.Effective(heavy).Timeout,
+		"pool setting not applied")
+
+	light := engine.GetPool("light")
+	assert.Equal(t, 50*time.Millisecond, engine.Budget{}.Effective(light).Timeout,
+		"global setting not applied")
+
+	var defaultPool *engine.Pool
+	assert.Equal(t, engine.PoolDefault, defaultPool.Name())
+	assert.Same(t, heavy, engine.GetPool("heavy"))
+}
+
+func TestPoolRun(t *testing.T) {
+	viper.Set("pools.single.max-in-flight", 1)
+	viper.Set("pools.single.queue-timeout", 1)
+	defer viper.Set("pools.single.max-in-flight", nil)
+	defer viper.Set("pools.single.queue-timeout", nil)
+
+	pool := engine.GetPool("single")
+
+	var taskPool *engine.Pool
+	res := pool.Run(context.Background(), poolEngine{&taskPool}, engine.Task{})
+	assert.NoError(t, res.Err)
+	assert.Same(t, pool, taskPool, "task not executed in the pool")
+
+	release, _ := pool.Limiter().Acquire(context.Background())
+	defer release()
+
+	res = pool.Run(context.Background(), poolEngine{&taskPool}, engine.Task{})
+	assert.ErrorIs(t, res.Err, engine.ErrOverloaded, "pool concurrency not respected")
+}
+
+func TestPoolLocal(t *testing.T) {
+	created := 0
+	local := engine.NewPoolLocal(func(pool *engine.Pool) string {
+		created++
+		return pool.Name()
+	})
+
+	assert.Equal(t, engine.PoolDefault, local.Get(nil))
+	assert.Equal(t, engine.PoolDefault, local.Get(nil))
+	assert.Equal(t, "local", local.Get(engine.GetPool("local")))
+	assert.Equal(t, 2, created, "value not reused")
+	assert.ElementsMatch(t, []string{engine.PoolDefault, "local"}, local.All())
+}
+
+// records the pool of the task
+type poolEngine struct {
+	pool **engine.Pool
+}
+
+func (e poolEngine) Run(ctx context.Context, task engine.Task) engine.RunResult {
+	*e.pool = task.Pool
+	return engine.RunResult{}
+}
+
+func (poolEngine) Name() string { return "pool" }
+
+func (poolEngine) Validate(pool *engine.Pool, code string) []*engine.ExecError { return nil }
+
+func (poolEngine) ClearCache(code string) {}
+
+func (poolEngine) CacheStats(pool *engine.Pool) cache.Stats { return cache.Stats{} }
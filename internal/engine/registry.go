@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var ErrUnknownLang = errors.New("unsupported language")
+
+var (
+	engines     = make(map[string]Engine)
+	enginesLock sync.RWMutex
+)
+
+// Register adds an engine to the registry, keyed by its name.
+// It panics if an engine with the same name is already registered.
+func Register(e Engine) {
+	enginesLock.Lock()
+	defer enginesLock.Unlock()
+
+	name := e.Name()
+	if _, exist := engines[name]; exist {
+		panic("engine already registered: " + name)
+	}
+
+	engines[name] = e
+}
+
+// Get returns the engine registered for lang.
+func Get(lang string) (Engine, error) {
+	enginesLock.RLock()
+	defer enginesLock.RUnlock()
+
+	e, exist := engines[lang]
+	if !exist {
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownLang, lang)
+	}
+
+	return e, nil
+}
+
+// Run dispatches the code to the engine registered for lang.
+func Run(ctx context.Context, lang string, task Task) RunResult {
+	e, err := Get(lang)
+	if err != nil {
+		return RunResult{Err: err}
+	}
+
+	return e.Run(ctx, task)
+}
+
+// Engines returns all registered engines ordered by name.
+func Engines() []Engine {
+	enginesLock.RLock()
+	defer enginesLock.RUnlock()
+
+	ret := make([]Engine, 0, len(engines))
+	for _, e := range engines {
+		ret = append(ret, e)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name() < ret[j].Name()
+	})
+
+	return ret
+}
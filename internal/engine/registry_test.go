@@ -0,0 +1,48 @@
+package engine_test
+
+import (
+	"context"
+	"service/internal/engine"
+	"service/internal/engine/cache"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type echoEngine struct{}
+
+func (echoEngine) Name() string { return "echo" }
+
+func (echoEngine) Run(ctx context.Context, task engine.Task) engine.RunResult {
+	return engine.RunResult{Val: task.Params}
+}
+
+func (echoEngine) Validate(pool *engine.Pool, code string) []*engine.ExecError { return nil }
+
+func (echoEngine) ClearCache(code string) {}
+
+func (echoEngine) CacheStats(pool *engine.Pool) cache.Stats { return cache.Stats{} }
+
+func init() {
+	engine.Register(echoEngine{})
+}
+
+func TestRegistry(t *testing.T) {
+	e, err := engine.Get("echo")
+	assert.NoError(t, err, "fail to get registered engine")
+	assert.Equal(t, "echo", e.Name())
+
+	res := engine.Run(context.Background(), "echo", engine.Task{Params: "{}"})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "{}", res.Val, "wrong returned result")
+
+	assert.Panics(t, func() { engine.Register(echoEngine{}) }, "duplicated registration")
+}
+
+func TestUnknownLang(t *testing.T) {
+	_, err := engine.Get("cobol")
+	assert.ErrorIs(t, err, engine.ErrUnknownLang)
+
+	res := engine.Run(context.Background(), "cobol", engine.Task{Params: "{}"})
+	assert.ErrorIs(t, res.Err, engine.ErrUnknownLang)
+}
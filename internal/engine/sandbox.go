@@ -0,0 +1,21 @@
+package engine
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrNoSandbox = errors.New("sandbox is enabled but not available")
+
+// Sandbox executes tasks out of process, isolating the service from
+// crashes and memory exhaustion of the code.
+type Sandbox interface {
+	Run(ctx context.Context, e Engine, task Task) RunResult
+}
+
+var sandbox Sandbox
+
+// SetSandbox sets the sandbox used by the pools with `sandbox` enabled.
+func SetSandbox(s Sandbox) {
+	sandbox = s
+}
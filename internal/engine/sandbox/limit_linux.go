@@ -0,0 +1,9 @@
+package sandbox
+
+import "syscall"
+
+// setMemoryLimit limits the data segment of the process, which covers the
+// heap of the Go runtime. Allocations beyond the limit crash the process.
+func setMemoryLimit(limit uint64) error {
+	return syscall.Setrlimit(syscall.RLIMIT_DATA, &syscall.Rlimit{Cur: limit, Max: limit})
+}
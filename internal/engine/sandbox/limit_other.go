@@ -0,0 +1,9 @@
+//go:build !linux
+
+package sandbox
+
+import "errors"
+
+func setMemoryLimit(limit uint64) error {
+	return errors.New("memory limit is only supported on linux")
+}
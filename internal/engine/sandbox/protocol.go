@@ -0,0 +1,130 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"service/internal/engine"
+	"time"
+)
+
+// Messages are exchanged as JSON values over the stdin and stdout of the
+// worker. The parent sends a task, and may then send a cancellation or
+// replies to module loads until the worker sends the result.
+
+// message from the parent to the worker
+type request struct {
+	Task   *taskMessage   `json:"task,omitempty"`
+	Cancel bool           `json:"cancel,omitempty"`
+	Module *moduleMessage `json:"module,omitempty"`
+}
+
+// message from the worker to the parent
+type response struct {
+	Result *resultMessage `json:"result,omitempty"`
+	Load   *loadMessage   `json:"load,omitempty"`
+}
+
+type taskMessage struct {
+	Lang        string             `json:"lang"`
+	Pool        string             `json:"pool"`
+	Code        string             `json:"code"`
+	Params      string             `json:"params"`
+	Meta        string             `json:"meta"`
+	Decls       []engine.ParamDecl `json:"decls,omitempty"`
+	Budget      engine.Budget      `json:"budget"`
+	CaptureLogs bool               `json:"capture_logs"`
+	Now         time.Time          `json:"now"`
+}
+
+type loadMessage struct {
+	Lang string `json:"lang"`
+	Name string `json:"name"`
+}
+
+type moduleMessage struct {
+	Module *engine.Module `json:"module,omitempty"`
+	Err    *errorMessage  `json:"error,omitempty"`
+}
+
+type resultMessage struct {
+	Val  string          `json:"val"`
+	JSON json.RawMessage `json:"json,omitempty"`
+	Logs []string        `json:"logs,omitempty"`
+	Err  *errorMessage   `json:"error,omitempty"`
+}
+
+// errors that keep their identity across the process boundary
+var sentinels = []error{
+	engine.ErrBudgetExceeded,
+	engine.ErrCancelled,
+	engine.ErrOverloaded,
+	engine.ErrUnknownModule,
+	engine.ErrCircularLoad,
+}
+
+type errorMessage struct {
+	Message  string              `json:"message"`
+	Exec     *engine.ExecError   `json:"exec,omitempty"`
+	Budget   *engine.BudgetError `json:"budget,omitempty"`
+	Sentinel int                 `json:"sentinel"` // 1-based index of sentinels
+}
+
+// remoteError is an error returned by the worker
+type remoteError struct {
+	msg      string
+	sentinel error
+}
+
+func (e *remoteError) Error() string {
+	return e.msg
+}
+
+func (e *remoteError) Unwrap() error {
+	return e.sentinel
+}
+
+func encodeError(err error) *errorMessage {
+	if err == nil {
+		return nil
+	}
+
+	msg := &errorMessage{Message: err.Error()}
+
+	var execErr *engine.ExecError
+	var budgetErr *engine.BudgetError
+
+	if errors.As(err, &execErr) {
+		msg.Exec = execErr
+	} else if errors.As(err, &budgetErr) {
+		msg.Budget = budgetErr
+	}
+
+	for i, sentinel := range sentinels {
+		if errors.Is(err, sentinel) {
+			msg.Sentinel = i + 1
+			break
+		}
+	}
+
+	return msg
+}
+
+func decodeError(msg *errorMessage) error {
+	if msg == nil {
+		return nil
+	}
+
+	if msg.Exec != nil {
+		return msg.Exec
+	}
+
+	if msg.Budget != nil {
+		return msg.Budget
+	}
+
+	err := &remoteError{msg: msg.Message}
+	if msg.Sentinel > 0 && msg.Sentinel <= len(sentinels) {
+		err.sentinel = sentinels[msg.Sentinel-1]
+	}
+	return err
+}
@@ -0,0 +1,6 @@
+//go:build race
+
+package sandbox_test
+
+// the race detector reserves more memory than the workers are limited to
+const raceEnabled = true
@@ -0,0 +1,313 @@
+// Package sandbox executes tasks in worker subprocesses of the same binary,
+// so that a crash or memory exhaustion only fails the task being executed.
+//
+// The main function of the binary must call Serve if IsWorker reports true,
+// after the engines are initialized.
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"service/internal/engine"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// time to wait for the worker after the budget is exceeded before killing it
+const killGrace = time.Second
+
+// delay before retrying to start a worker
+const restartDelay = time.Second
+
+// bytes of the stderr of the worker kept to diagnose crashes
+const stderrTail = 4096
+
+// part of the fatal error of the Go runtime when allocations fail
+const outOfMemory = "out of memory"
+
+var (
+	ErrCrashed = errors.New("sandbox worker crashed")
+	ErrBusy    = fmt.Errorf("sandbox busy: %w", engine.ErrOverloaded)
+)
+
+type Sandbox struct{}
+
+func init() {
+	engine.SetSandbox(Sandbox{})
+}
+
+// worker pools of each pool
+var workerPools = engine.NewPoolLocal(func(pool *engine.Pool) *workerPool {
+	return newWorkerPool(
+		pool.GetInt("sandbox-workers"),
+		pool.GetUint64("sandbox-memory-limit")<<20, // in MiB
+	)
+})
+
+func (Sandbox) Run(ctx context.Context, e engine.Engine, task engine.Task) engine.RunResult {
+	task.Budget = task.Budget.Effective(task.Pool)
+	workers := workerPools.Get(task.Pool)
+
+	w, err := workers.checkout(ctx, task.Budget.Timeout)
+	if err != nil {
+		return engine.RunResult{Err: err}
+	}
+
+	res, healthy := w.run(ctx, e.Name(), task)
+	if healthy {
+		workers.checkin(w)
+	} else {
+		workers.replace(w)
+	}
+
+	return res
+}
+
+// workerPool holds a fixed number of workers, each executing one task at
+// a time. Workers are started in the background and restarted on failure.
+type workerPool struct {
+	limit   uint64 // memory limit of each worker in bytes, 0 for no limit
+	workers chan *worker
+}
+
+func newWorkerPool(size int, limit uint64) *workerPool {
+	if size <= 0 {
+		size = runtime.NumCPU()
+	}
+
+	p := &workerPool{
+		limit:   limit,
+		workers: make(chan *worker, size),
+	}
+
+	for i := 0; i < size; i++ {
+		go p.start()
+	}
+
+	return p
+}
+
+// start a worker into the pool, retrying until succeeded
+func (p *workerPool) start() {
+	for {
+		w, err := startWorker(p.limit)
+		if err == nil {
+			p.workers <- w
+			return
+		}
+
+		log.Print("fail to start sandbox worker: ", err)
+		time.Sleep(restartDelay)
+	}
+}
+
+// checkout waits at most timeout for an idle worker, or until ctx is done
+func (p *workerPool) checkout(ctx context.Context, timeout time.Duration) (*worker, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case w := <-p.workers:
+		return w, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %v", engine.ErrCancelled, ctx.Err())
+	case <-timer.C:
+		return nil, ErrBusy
+	}
+}
+
+func (p *workerPool) checkin(w *worker) {
+	p.workers <- w
+}
+
+// replace kills the worker and starts a new one
+func (p *workerPool) replace(w *worker) {
+	w.kill()
+	go p.start()
+}
+
+type worker struct {
+	cmd       *exec.Cmd
+	enc       *json.Encoder
+	responses chan response
+	stderr    *stderrWatcher
+	limit     uint64
+	exitErr   error // set before responses is closed
+}
+
+func startWorker(limit uint64) (*worker, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &worker{
+		cmd:       exec.Command(exe, os.Args[1:]...),
+		responses: make(chan response),
+		stderr:    &stderrWatcher{},
+		limit:     limit,
+	}
+
+	w.cmd.Env = append(os.Environ(), workerEnv+"="+strconv.FormatUint(limit, 10))
+	w.cmd.Stderr = w.stderr
+
+	stdin, err := w.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := w.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	w.enc = json.NewEncoder(stdin)
+
+	go func() {
+		dec := json.NewDecoder(stdout)
+		for {
+			var res response
+			if err := dec.Decode(&res); err != nil {
+				break
+			}
+			w.responses <- res
+		}
+
+		w.exitErr = w.cmd.Wait()
+		close(w.responses)
+	}()
+
+	return w, nil
+}
+
+func (w *worker) kill() {
+	w.cmd.Process.Kill()
+
+	// unblock the reader until the worker exits
+	go func() {
+		for range w.responses {
+		}
+	}()
+}
+
+// run executes the task in the worker, returning whether the worker can
+// be reused. The worker enforces the budget itself, and is considered
+// broken if it fails to respond in time.
+func (w *worker) run(ctx context.Context, lang string, task engine.Task) (engine.RunResult, bool) {
+	err := w.enc.Encode(request{Task: &taskMessage{
+		Lang:        lang,
+		Pool:        task.Pool.Name(),
+		Code:        task.Code,
+		Params:      task.Params,
+		Meta:        task.Meta,
+		Decls:       task.Decls,
+		Budget:      task.Budget,
+		CaptureLogs: task.CaptureLogs,
+		Now:         task.Now,
+	}})
+	if err != nil {
+		return engine.RunResult{Err: fmt.Errorf("%w: %v", ErrCrashed, err)}, false
+	}
+
+	timer := time.NewTimer(task.Budget.Timeout + killGrace)
+	defer timer.Stop()
+
+	done := ctx.Done()
+
+	for {
+		select {
+		case res, ok := <-w.responses:
+			if !ok {
+				return engine.RunResult{Err: w.crashError()}, false
+			}
+
+			if res.Load != nil {
+				module, err := loadModule(task.Modules, res.Load)
+				err = w.enc.Encode(request{Module: &moduleMessage{
+					Module: module,
+					Err:    encodeError(err),
+				}})
+				if err != nil {
+					return engine.RunResult{Err: fmt.Errorf("%w: %v", ErrCrashed, err)}, false
+				}
+				continue
+			}
+
+			if res.Result != nil {
+				return engine.RunResult{
+					Val:  res.Result.Val,
+					JSON: res.Result.JSON,
+					Logs: res.Result.Logs,
+					Err:  decodeError(res.Result.Err),
+				}, true
+			}
+		case <-done:
+			// wait for the worker to return the cancellation
+			w.enc.Encode(request{Cancel: true})
+			done = nil
+		case <-timer.C:
+			return engine.RunResult{Err: engine.ContextError(ctx, task.Budget.Timeout)}, false
+		}
+	}
+}
+
+func loadModule(loader engine.ModuleLoader, msg *loadMessage) (*engine.Module, error) {
+	if loader == nil {
+		return nil, fmt.Errorf("%w '%s'", engine.ErrUnknownModule, msg.Name)
+	}
+	return loader(msg.Lang, msg.Name)
+}
+
+// error of a task after the worker exits unexpectedly
+func (w *worker) crashError() error {
+	if w.limit == 0 {
+		return fmt.Errorf("%w: %v", ErrCrashed, w.exitErr)
+	}
+
+	if w.stderr.outOfMemory() {
+		return engine.MemoryError(w.limit)
+	}
+	// the runtime may crash otherwise when allocations fail
+	return fmt.Errorf("%w with memory limit of %d MiB: %v", ErrCrashed, w.limit>>20, w.exitErr)
+}
+
+// stderrWatcher forwards the output to the stderr of the process, watching
+// for the crash caused by allocation failures
+type stderrWatcher struct {
+	mu   sync.Mutex
+	tail []byte // last bytes written
+	oom  bool
+}
+
+func (w *stderrWatcher) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.tail = append(w.tail, p...)
+	if strings.Contains(string(w.tail), outOfMemory) {
+		w.oom = true
+	}
+	if len(w.tail) > stderrTail {
+		w.tail = w.tail[len(w.tail)-stderrTail:]
+	}
+
+	return os.Stderr.Write(p)
+}
+
+func (w *stderrWatcher) outOfMemory() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.oom
+}
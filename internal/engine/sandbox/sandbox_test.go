@@ -0,0 +1,139 @@
+package sandbox_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"service/internal/engine"
+	"service/internal/engine/javascript"
+	"service/internal/engine/sandbox"
+	"service/internal/engine/starlark"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+var ctx = context.Background()
+
+func TestMain(m *testing.M) {
+	if err := javascript.Init(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	viper.SetDefault("timeout", 1000)
+	// set before the workers are started, which read the same settings
+	viper.SetDefault("pools.restricted.starlark-modules", []string{"math"})
+
+	// the test binary is also the worker
+	if sandbox.IsWorker() {
+		sandbox.Serve()
+		os.Exit(0)
+	}
+
+	viper.SetDefault("sandbox", true)
+	viper.SetDefault("sandbox-workers", 2)
+
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestRun(t *testing.T) {
+	pool := engine.GetPool("run")
+
+	res := pool.Run(ctx, starlark.Engine{}, engine.Task{
+		Code:        "print(p[\"a\"])\nreturn {\"a\": p[\"a\"]}",
+		Params:      `{"a": 1}`,
+		CaptureLogs: true,
+	})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.JSONEq(t, `{"a": 1}`, string(res.JSON), "wrong returned result")
+	assert.Equal(t, []string{"1"}, res.Logs, "wrong captured logs")
+
+	res = pool.Run(ctx, javascript.Engine{}, engine.Task{Code: "return p.a + 1", Params: `{"a": 1}`})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "2", res.Val, "wrong returned result")
+}
+
+func TestPoolSettings(t *testing.T) {
+	code := "return struct(a = 1).a"
+
+	res := engine.GetPool("unrestricted").Run(ctx, starlark.Engine{}, engine.Task{Code: code, Params: "{}"})
+	assert.NoError(t, res.Err, "runner returned an error")
+
+	res = engine.GetPool("restricted").Run(ctx, starlark.Engine{}, engine.Task{Code: code, Params: "{}"})
+	assert.ErrorContains(t, res.Err, "undefined: struct", "module allowlist of the pool not applied")
+}
+
+func TestErrors(t *testing.T) {
+	pool := engine.GetPool("errors")
+
+	res := pool.Run(ctx, starlark.Engine{}, engine.Task{Code: "x = 1\nreturn (", Params: "{}"})
+	err := engine.Describe(res.Err)
+	assert.Equal(t, engine.ErrorSyntax, err.Kind, "wrong error kind")
+	assert.Equal(t, 2, err.Line, "wrong error line")
+
+	res = pool.Run(ctx, starlark.Engine{}, engine.Task{
+		Code:   "for i in range(100000000000):\n\tpass",
+		Params: "{}",
+		Budget: engine.Budget{Timeout: 20 * time.Millisecond},
+	})
+	assert.ErrorIs(t, res.Err, engine.ErrBudgetExceeded, "budget not enforced")
+
+	res = pool.Run(ctx, starlark.Engine{}, engine.Task{Code: "load(\"lib/none\", \"x\")\nreturn x", Params: "{}"})
+	assert.ErrorContains(t, res.Err, "unknown module 'lib/none'")
+}
+
+func TestModules(t *testing.T) {
+	loader := func(lang, name string) (*engine.Module, error) {
+		return &engine.Module{Name: name, Version: 1, Code: "x = 42"}, nil
+	}
+
+	res := engine.GetPool("modules").Run(ctx, starlark.Engine{}, engine.Task{
+		Code:    "load(\"lib/x\", \"x\")\nreturn x",
+		Params:  "{}",
+		Modules: loader,
+	})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "42", res.Val, "module not loaded through the parent")
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	res := engine.GetPool("cancel").Run(ctx, starlark.Engine{}, engine.Task{
+		Code:   "for i in range(100000000000):\n\tpass",
+		Params: "{}",
+	})
+	assert.ErrorIs(t, res.Err, engine.ErrCancelled, "fail to exit on cancellation")
+}
+
+func TestMemoryLimit(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the workers run out of memory with the race detector")
+	}
+
+	viper.Set("pools.limited.sandbox-memory-limit", 128)
+	viper.Set("pools.limited.sandbox-workers", 1)
+	defer viper.Set("pools.limited.sandbox-memory-limit", nil)
+	defer viper.Set("pools.limited.sandbox-workers", nil)
+
+	pool := engine.GetPool("limited")
+
+	res := pool.Run(ctx, starlark.Engine{}, engine.Task{
+		Code:   `return len("x" * 1000000000)`,
+		Params: "{}",
+	})
+	// the runtime may also fail with a segmentation fault instead of the
+	// out of memory error
+	assert.True(t, errors.Is(res.Err, engine.ErrBudgetExceeded) || errors.Is(res.Err, sandbox.ErrCrashed),
+		"memory limit not enforced")
+
+	// the crashed worker is restarted
+	res = pool.Run(ctx, starlark.Engine{}, engine.Task{Code: "return 1", Params: "{}"})
+	assert.NoError(t, res.Err, "worker not restarted")
+}
@@ -0,0 +1,119 @@
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"service/internal/engine"
+	"strconv"
+)
+
+// environment variable marking a worker process, holding its memory
+// limit in bytes
+const workerEnv = "SANDBOX_WORKER_MEMORY_LIMIT"
+
+// IsWorker reports whether the process is started as a sandbox worker.
+func IsWorker() bool {
+	_, ok := os.LookupEnv(workerEnv)
+	return ok
+}
+
+// the task being executed by the worker
+type workerRun struct {
+	cancel  context.CancelFunc
+	modules chan *moduleMessage
+}
+
+// Serve executes the tasks sent by the parent process until the stdin is
+// closed. Engines must be initialized before.
+func Serve() {
+	if limit, _ := strconv.ParseUint(os.Getenv(workerEnv), 10, 64); limit > 0 {
+		if err := setMemoryLimit(limit); err != nil {
+			log.Print("fail to set memory limit: ", err)
+		}
+	}
+
+	// keep stdout for messages only
+	enc := json.NewEncoder(os.Stdout)
+	os.Stdout = os.Stderr
+
+	dec := json.NewDecoder(os.Stdin)
+	var current *workerRun
+
+	for {
+		var req request
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+
+		switch {
+		case req.Task != nil:
+			current = startRun(enc, req.Task)
+		case req.Cancel && current != nil:
+			current.cancel()
+		case req.Module != nil && current != nil:
+			select {
+			case current.modules <- req.Module:
+			default: // stale reply
+			}
+		}
+	}
+}
+
+func startRun(enc *json.Encoder, msg *taskMessage) *workerRun {
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &workerRun{
+		cancel:  cancel,
+		modules: make(chan *moduleMessage, 1),
+	}
+
+	// load modules through the parent
+	loader := func(lang string, name string) (*engine.Module, error) {
+		if err := enc.Encode(response{Load: &loadMessage{Lang: lang, Name: name}}); err != nil {
+			return nil, err
+		}
+
+		select {
+		case reply := <-run.modules:
+			return reply.Module, decodeError(reply.Err)
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %v", engine.ErrCancelled, ctx.Err())
+		}
+	}
+
+	go func() {
+		defer cancel()
+
+		var res engine.RunResult
+		if e, err := engine.Get(msg.Lang); err != nil {
+			res.Err = err
+		} else {
+			// settings of the pool, e.g. the allowed modules
+			res = e.Run(ctx, engine.Task{
+				Pool:        engine.GetPool(msg.Pool),
+				Code:        msg.Code,
+				Params:      msg.Params,
+				Meta:        msg.Meta,
+				Decls:       msg.Decls,
+				Budget:      msg.Budget,
+				CaptureLogs: msg.CaptureLogs,
+				Now:         msg.Now,
+				Modules:     loader,
+			})
+		}
+
+		err := enc.Encode(response{Result: &resultMessage{
+			Val:  res.Val,
+			JSON: res.JSON,
+			Logs: res.Logs,
+			Err:  encodeError(res.Err),
+		}})
+		if err != nil {
+			log.Print(err)
+		}
+	}()
+
+	return run
+}
@@ -0,0 +1,101 @@
+package starlark
+
+import (
+	"service/internal/engine"
+	"strings"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// file name of the wrapped code
+const fileName = "runner"
+
+// lines of runnerCode before the user's code
+var headerLines = strings.Count(runnerCode, "\n")
+
+// indentation added to each line of the user's code
+const indentWidth = 4
+
+// name of the frame of the user's top level code
+const mainFrame = "<main>"
+
+func toUserPos(code string, pos syntax.Position) (int, int) {
+	return engine.ClampPosition(code, int(pos.Line)-headerLines, int(pos.Col)-indentWidth)
+}
+
+// translate errors from the wrapped code into the user's code
+func translateError(code string, err error) error {
+	switch e := err.(type) {
+	case syntax.Error:
+		line, column := toUserPos(code, e.Pos)
+		return &engine.ExecError{
+			Kind:    engine.ErrorSyntax,
+			Line:    line,
+			Column:  column,
+			Message: e.Msg,
+		}
+	case resolve.ErrorList:
+		return translateErrors(code, e)[0]
+	case *starlark.EvalError:
+		execErr := &engine.ExecError{
+			Kind:    engine.ErrorRuntime,
+			Message: e.Msg,
+		}
+
+		for i := len(e.CallStack) - 1; i >= 0; i-- {
+			frame := e.CallStack[i]
+			if frame.Pos.Filename() != fileName {
+				// builtin functions
+				continue
+			}
+
+			name := frame.Name
+			if name == "run" {
+				name = mainFrame
+			}
+
+			line, column := toUserPos(code, frame.Pos)
+			execErr.Stack = append(execErr.Stack, engine.Frame{
+				Name:   name,
+				Line:   line,
+				Column: column,
+			})
+		}
+
+		if len(execErr.Stack) > 0 {
+			execErr.Line = execErr.Stack[0].Line
+			execErr.Column = execErr.Stack[0].Column
+		}
+
+		return execErr
+	}
+
+	return err
+}
+
+// translate all errors, including each of an error list
+func translateErrors(code string, err error) []*engine.ExecError {
+	if err == nil {
+		return nil
+	}
+
+	list, ok := err.(resolve.ErrorList)
+	if !ok {
+		return []*engine.ExecError{engine.Describe(translateError(code, err))}
+	}
+
+	errs := make([]*engine.ExecError, len(list))
+	for i, e := range list {
+		line, column := toUserPos(code, e.Pos)
+		errs[i] = &engine.ExecError{
+			Kind:    engine.ErrorSyntax,
+			Line:    line,
+			Column:  column,
+			Message: e.Msg,
+		}
+	}
+
+	return errs
+}
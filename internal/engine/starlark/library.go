@@ -0,0 +1,78 @@
+package starlark
+
+import (
+	"service/internal/engine"
+	"service/internal/engine/cache"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// compiled library module caches of each pool, keyed by module version
+var moduleCaches = engine.NewPoolLocal(func(pool *engine.Pool) *cache.LRU[*starlark.Program] {
+	return cache.New[*starlark.Program](
+		pool.GetInt("code-cache-size"),
+		pool.GetDuration("code-cache-expiration"),
+	)
+})
+
+func compileModule(pool *engine.Pool, module *engine.Module, predeclared starlark.StringDict) (*starlark.Program, error) {
+	caches := moduleCaches.Get(pool)
+	if program, cacheExist := caches.Get(module.Key()); cacheExist {
+		return program, nil
+	}
+
+	_, program, err := starlark.SourceProgram(module.Name, module.Code, predeclared.Has)
+	if err != nil {
+		return nil, err
+	}
+
+	caches.Add(module.Key(), program)
+	return program, nil
+}
+
+// newLoader returns the load function of a thread, executing each module
+// in the thread with the same predeclared names as the user's code
+func newLoader(pool *engine.Pool, modules engine.ModuleLoader, predeclared starlark.StringDict) func(*starlark.Thread, string) (starlark.StringDict, error) {
+	loads := engine.NewLoads[starlark.StringDict](Engine{}.Name(), modules)
+
+	return func(thread *starlark.Thread, name string) (starlark.StringDict, error) {
+		return loads.Load(name, func(module *engine.Module) (starlark.StringDict, error) {
+			program, err := compileModule(pool, module, predeclared)
+			if err != nil {
+				return nil, err
+			}
+
+			globals, err := program.Init(thread, predeclared)
+			globals.Freeze()
+			return globals, err
+		})
+	}
+}
+
+// move the load statements at the top level of the runner function to the
+// top level of the file, as loads are not allowed within a function
+func hoistLoads(file *syntax.File) {
+	if len(file.Stmts) == 0 {
+		return
+	}
+
+	runner, ok := file.Stmts[0].(*syntax.DefStmt)
+	if !ok {
+		return
+	}
+
+	var loads []syntax.Stmt
+	body := runner.Body[:0]
+
+	for _, stmt := range runner.Body {
+		if load, ok := stmt.(*syntax.LoadStmt); ok {
+			loads = append(loads, load)
+		} else {
+			body = append(body, stmt)
+		}
+	}
+
+	runner.Body = body
+	file.Stmts = append(loads, file.Stmts...)
+}
@@ -0,0 +1,103 @@
+package starlark
+
+import (
+	"fmt"
+	"service/internal/engine"
+	"service/internal/utils"
+	"sort"
+	"time"
+
+	"go.starlark.net/lib/json"
+	"go.starlark.net/lib/math"
+	startime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// optional modules available to the code, keyed by predeclared name
+var modules = map[string]starlark.Value{
+	"math":   math.Module,
+	"time":   startime.Module,
+	"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+	"semver": semverModule,
+	"re":     reModule,
+}
+
+// names of the modules allowed in each pool, configured by
+// `starlark-modules` and defaulting to all modules
+var allowedModules = engine.NewPoolLocal(func(pool *engine.Pool) []string {
+	if pool.IsSet("starlark-modules") {
+		return pool.GetStringSlice("starlark-modules")
+	}
+
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+})
+
+// Modules returns the names of the modules allowed in the pool.
+func Modules(pool *engine.Pool) []string {
+	return allowedModules.Get(pool)
+}
+
+// predeclared returns the names predeclared to the code run in the pool,
+// with `time.now()` fixed to now so that the code stays deterministic
+func predeclared(pool *engine.Pool, now time.Time) starlark.StringDict {
+	dict := make(starlark.StringDict, len(json.Module.Members)+len(modules)+1)
+	for name, val := range json.Module.Members {
+		dict[name] = val
+	}
+
+	dict["bucket"] = bucketBuiltin
+
+	for _, name := range allowedModules.Get(pool) {
+		if name == "time" {
+			dict[name] = timeModule(now)
+		} else if val, exist := modules[name]; exist {
+			dict[name] = val
+		}
+	}
+
+	return dict
+}
+
+// copy of the time module whose now() returns the given time
+func timeModule(now time.Time) *starlarkstruct.Module {
+	members := make(starlark.StringDict, len(startime.Module.Members))
+	for name, val := range startime.Module.Members {
+		members[name] = val
+	}
+
+	members["now"] = starlark.NewBuiltin("now", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+			return nil, err
+		}
+		return startime.Time(now), nil
+	})
+
+	module := &starlarkstruct.Module{Name: "time", Members: members}
+	module.Freeze()
+	return module
+}
+
+var bucketBuiltin = starlark.NewBuiltin("bucket", bucket)
+
+// bucket(key, salt, n) maps the salted key into [0, n) with the same
+// hashing as the gray release of the service
+func bucket(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key, salt string
+	var n int64
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key, "salt", &salt, "n", &n); err != nil {
+		return nil, err
+	}
+
+	bucket, err := utils.Bucket(key, salt, n)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w, got %d", b.Name(), err, n)
+	}
+
+	return starlark.MakeUint(uint(bucket)), nil
+}
@@ -0,0 +1,110 @@
+package starlark
+
+import (
+	"fmt"
+	"regexp"
+	"service/internal/engine/cache"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// re module with RE2 syntax, which runs in linear time
+var reModule = &starlarkstruct.Module{
+	Name: "re",
+	Members: starlark.StringDict{
+		"match":    starlark.NewBuiltin("match", reMatch),
+		"find":     starlark.NewBuiltin("find", reFind),
+		"find_all": starlark.NewBuiltin("find_all", reFindAll),
+		"sub":      starlark.NewBuiltin("sub", reSub),
+		"split":    starlark.NewBuiltin("split", reSplit),
+	},
+}
+
+// compiled patterns shared by all runs
+var patterns = cache.New[*regexp.Regexp](cache.DefaultCapacity, 0)
+
+func compilePattern(b *starlark.Builtin, pattern string) (*regexp.Regexp, error) {
+	if re, exist := patterns.Get(pattern); exist {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+
+	patterns.Add(pattern, re)
+	return re, nil
+}
+
+// unpack (pattern, s) and compile the pattern
+func unpackPattern(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (*regexp.Regexp, string, error) {
+	var pattern, s string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "pattern", &pattern, "s", &s); err != nil {
+		return nil, "", err
+	}
+
+	re, err := compilePattern(b, pattern)
+	return re, s, err
+}
+
+func stringList(strs []string) *starlark.List {
+	elems := make([]starlark.Value, len(strs))
+	for i, str := range strs {
+		elems[i] = starlark.String(str)
+	}
+	return starlark.NewList(elems)
+}
+
+func reMatch(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	re, s, err := unpackPattern(b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.Bool(re.MatchString(s)), nil
+}
+
+// find returns the submatches of the leftmost match, or None
+func reFind(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	re, s, err := unpackPattern(b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+
+	match := re.FindStringSubmatch(s)
+	if match == nil {
+		return starlark.None, nil
+	}
+	return stringList(match), nil
+}
+
+func reFindAll(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	re, s, err := unpackPattern(b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return stringList(re.FindAllString(s, -1)), nil
+}
+
+// sub replaces all matches with repl, expanding `$1` and `${name}`
+func reSub(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pattern, repl, s string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "pattern", &pattern, "repl", &repl, "s", &s); err != nil {
+		return nil, err
+	}
+
+	re, err := compilePattern(b, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(re.ReplaceAllString(s, repl)), nil
+}
+
+func reSplit(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	re, s, err := unpackPattern(b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return stringList(re.Split(s, -1)), nil
+}
@@ -0,0 +1,160 @@
+package starlark
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// semver module, parsing and comparing versions like `v1.2.3-beta.1+build`,
+// where the minor and patch numbers may be omitted
+var semverModule = &starlarkstruct.Module{
+	Name: "semver",
+	Members: starlark.StringDict{
+		"parse":   starlark.NewBuiltin("parse", semverParse),
+		"valid":   starlark.NewBuiltin("valid", semverValid),
+		"compare": starlark.NewBuiltin("compare", semverCompare),
+	},
+}
+
+type semVersion struct {
+	major, minor, patch uint64
+	prerelease          []string
+	build               string
+}
+
+func parseVersion(s string) (*semVersion, error) {
+	v := &semVersion{}
+	rest := strings.TrimPrefix(s, "v")
+
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		v.build = rest[i+1:]
+		rest = rest[:i]
+	}
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		v.prerelease = strings.Split(rest[i+1:], ".")
+		rest = rest[:i]
+		for _, id := range v.prerelease {
+			if id == "" {
+				return nil, fmt.Errorf("invalid version '%s'", s)
+			}
+		}
+	}
+
+	numbers := strings.Split(rest, ".")
+	if len(numbers) > 3 {
+		return nil, fmt.Errorf("invalid version '%s'", s)
+	}
+
+	fields := []*uint64{&v.major, &v.minor, &v.patch}
+	for i, number := range numbers {
+		n, err := strconv.ParseUint(number, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version '%s'", s)
+		}
+		*fields[i] = n
+	}
+
+	return v, nil
+}
+
+func compareUint(a, b uint64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// compare the versions by precedence, ignoring build metadata
+func (v *semVersion) compare(o *semVersion) int {
+	if c := compareUint(v.major, o.major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.minor, o.minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.patch, o.patch); c != 0 {
+		return c
+	}
+
+	// a version without prerelease has higher precedence
+	if len(v.prerelease) == 0 || len(o.prerelease) == 0 {
+		return compareUint(uint64(len(o.prerelease)), uint64(len(v.prerelease)))
+	}
+
+	for i := 0; i < len(v.prerelease) && i < len(o.prerelease); i++ {
+		a, errA := strconv.ParseUint(v.prerelease[i], 10, 64)
+		b, errB := strconv.ParseUint(o.prerelease[i], 10, 64)
+
+		var c int
+		switch {
+		case errA == nil && errB == nil:
+			c = compareUint(a, b)
+		case errA == nil: // numeric identifiers are lower
+			c = -1
+		case errB == nil:
+			c = 1
+		default:
+			c = strings.Compare(v.prerelease[i], o.prerelease[i])
+		}
+
+		if c != 0 {
+			return c
+		}
+	}
+
+	return compareUint(uint64(len(v.prerelease)), uint64(len(o.prerelease)))
+}
+
+func semverParse(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var s string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+
+	v, err := parseVersion(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"major":      starlark.MakeUint64(v.major),
+		"minor":      starlark.MakeUint64(v.minor),
+		"patch":      starlark.MakeUint64(v.patch),
+		"prerelease": starlark.String(strings.Join(v.prerelease, ".")),
+		"build":      starlark.String(v.build),
+	}), nil
+}
+
+func semverValid(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var s string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+
+	_, err := parseVersion(s)
+	return starlark.Bool(err == nil), nil
+}
+
+func semverCompare(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x, y string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &x, &y); err != nil {
+		return nil, err
+	}
+
+	vx, err := parseVersion(x)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	vy, err := parseVersion(y)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+
+	return starlark.MakeInt(vx.compare(vy)), nil
+}
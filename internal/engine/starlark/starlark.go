@@ -2,114 +2,151 @@ package starlark
 
 import (
 	"context"
-	"fmt"
 	"service/internal/engine"
-	"service/internal/utils"
+	"service/internal/engine/cache"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/spf13/viper"
 	"go.starlark.net/lib/json"
 	"go.starlark.net/starlark"
 	"go.starlark.net/syntax"
 )
 
-type codeCache struct {
-	id         string
-	program    *starlark.Program
-	cachedTime time.Time
-}
-
-const runnerCode = `def run(p):
+const runnerCode = `def run(p, meta):
     p = decode(p)
+    meta = decode(meta)
 `
-const maxCaches = 100
 
-var (
-	codeCaches = make(map[string]codeCache)
-	cacheKeys  []string
-	cacheLock  sync.RWMutex
-)
+// compiled code caches of each pool
+var codeCaches = engine.NewPoolLocal(func(pool *engine.Pool) *cache.LRU[*starlark.Program] {
+	return cache.New[*starlark.Program](
+		pool.GetInt("code-cache-size"),
+		pool.GetDuration("code-cache-expiration"),
+	)
+})
 
-func ClearCache(id string) {
-	cacheLock.Lock()
-	defer cacheLock.Unlock()
+// version of the interpreter, part of the compiled code cache keys
+var version = engine.ModuleVersion("go.starlark.net")
 
-	index := utils.Find(cacheKeys, id)
-	if index < 0 {
-		return
-	}
-
-	delete(codeCaches, id)
-	cacheKeys = utils.Remove(cacheKeys, index)
+func cacheKey(code string) string {
+	return engine.CacheKey(Engine{}.Name(), version, code)
 }
 
-func execFile(thread *starlark.Thread, id string, code string, predeclared starlark.StringDict) (starlark.StringDict, error) {
-	cacheLock.RLock() // acquire read lock
-	cache, cacheExist := codeCaches[id]
-	cacheLock.RUnlock() // release read lock
+func ClearCache(code string) {
+	key := cacheKey(code)
+	for _, caches := range codeCaches.All() {
+		caches.Remove(key)
+	}
+}
 
-	program := cache.program
+func CacheStats(pool *engine.Pool) cache.Stats {
+	return codeCaches.Get(pool).Stats()
+}
 
-	// check if cache has expired
-	ttl := viper.GetDuration("code-cache-expiration")
-	if cacheExist && time.Since(cache.cachedTime) > ttl {
-		ClearCache(cache.id)
-		cacheExist = false
-	}
+func execFile(thread *starlark.Thread, task engine.Task, predeclared starlark.StringDict) (starlark.StringDict, error) {
+	caches := codeCaches.Get(task.Pool)
+	key := cacheKey(task.Code)
+	program, cacheExist := caches.Get(key)
 
 	if !cacheExist {
-		file, err := syntax.Parse("runner "+id, code, 0)
+		var err error
+		program, err = compile(task.Programs, wrapCode(task.Code), predeclared)
 		if err != nil {
 			return nil, err
 		}
 
-		program, err = starlark.FileProgram(file, predeclared.Has)
+		caches.Add(key, program)
+	}
 
-		if err != nil {
-			return nil, err
-		}
+	globals, err := program.Init(thread, predeclared)
+	globals.Freeze()
 
-		if id != "" { // empty string indicating no-cache
-			cacheLock.Lock() // acquire mutex
+	return globals, err
+}
 
-			codeCaches[id] = codeCache{
-				id:         id,
-				program:    program,
-				cachedTime: time.Now(),
-			}
-			cacheKeys = append(cacheKeys, id)
+type Engine struct{}
 
-			if len(cacheKeys) > maxCaches {
-				// remove oldest cache when exceeded
-				delete(codeCaches, cacheKeys[0])
-				cacheKeys = cacheKeys[1:]
-			}
+func init() {
+	engine.Register(Engine{})
+}
 
-			cacheLock.Unlock() // release mutex
-		}
-	}
+func (Engine) Name() string {
+	return "starlark"
+}
 
-	globals, err := program.Init(thread, json.Module.Members)
-	globals.Freeze()
+func (Engine) Run(ctx context.Context, task engine.Task) engine.RunResult {
+	return Run(ctx, task)
+}
 
-	return globals, err
+func (Engine) Validate(pool *engine.Pool, code string) []*engine.ExecError {
+	return Validate(pool, code)
 }
 
-func Run(id string, code string, params string) engine.RunResult {
-	// pre-process code
-	thread := &starlark.Thread{}
-	code = strings.Replace(code, "\n", "\n    ", -1)
-	globals, err := execFile(thread, id, runnerCode+"\n    "+code, json.Module.Members)
+func (Engine) MetaFields(code string) ([]string, bool) {
+	return engine.ScanMetaFields(code)
+}
 
+func (Engine) ClearCache(code string) {
+	ClearCache(code)
+}
+
+func (Engine) CacheStats(pool *engine.Pool) cache.Stats {
+	return CacheStats(pool)
+}
+
+// wrap the code into the body of the runner function
+func wrapCode(code string) string {
+	return runnerCode + "    " + strings.Replace(code, "\n", "\n    ", -1)
+}
+
+// Validate parses and resolves the code against the modules allowed in
+// pool, returning all errors found.
+func Validate(pool *engine.Pool, code string) []*engine.ExecError {
+	file, err := syntax.Parse(fileName, wrapCode(code), 0)
 	if err != nil {
-		return engine.RunResult{Err: err}
+		return translateErrors(code, err)
+	}
+	hoistLoads(file)
+
+	_, err = starlark.FileProgram(file, predeclared(pool, time.Time{}).Has)
+	return translateErrors(code, err)
+}
+
+// encode the value with the json module, returning nil if not serializable
+func encodeJSON(thread *starlark.Thread, val starlark.Value) []byte {
+	encoded, err := starlark.Call(thread, json.Module.Members["encode"], starlark.Tuple{val}, nil)
+	if err != nil {
+		return nil
+	}
+
+	str, ok := starlark.AsString(encoded)
+	if !ok {
+		return nil
+	}
+
+	return []byte(str)
+}
+
+func Run(parent context.Context, task engine.Task) engine.RunResult {
+	budget := task.Budget.Effective(task.Pool)
+
+	logs := engine.NewLogBuffer(task.CaptureLogs)
+
+	now := task.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	predeclared := predeclared(task.Pool, now)
+
+	thread := &starlark.Thread{
+		Print: func(_ *starlark.Thread, msg string) {
+			logs.Print(msg)
+		},
+		Load: newLoader(task.Pool, task.Modules, predeclared),
 	}
 
-	// set timeout
-	timeout := viper.GetDuration("timeout")
-	ctx, cancel := context.WithTimeout(context.Background(), timeout*time.Millisecond)
+	// set timeout, covering the top level code of the loaded modules
+	ctx, cancel := context.WithTimeout(parent, budget.Timeout)
 	defer cancel()
 
 	// result channel
@@ -118,13 +155,31 @@ func Run(id string, code string, params string) engine.RunResult {
 	go func() {
 		res := engine.RunResult{}
 
+		// pre-process code
+		globals, err := execFile(thread, task, predeclared)
+		if err != nil {
+			res.Err = translateError(task.Code, err)
+			ch <- res
+			return
+		}
+
+		// only count the steps of the runner function
+		startSteps := thread.ExecutionSteps()
+		if budget.MaxSteps > 0 {
+			thread.SetMaxExecutionSteps(startSteps + budget.MaxSteps)
+		}
+
 		runnerFunc := globals["run"]
 
 		if val, err := starlark.Call(thread, runnerFunc,
-			starlark.Tuple{starlark.String(params)}, nil); err != nil {
-			res.Err = err
+			starlark.Tuple{starlark.String(task.Params), starlark.String(task.MetaJSON())}, nil); err != nil {
+			res.Err = translateError(task.Code, err)
+			if budget.MaxSteps > 0 && thread.ExecutionSteps()-startSteps >= budget.MaxSteps {
+				res.Err = engine.StepsError(budget.MaxSteps)
+			}
 		} else {
 			res.Val = val.String()
+			res.JSON = encodeJSON(thread, val)
 		}
 
 		ch <- res
@@ -135,9 +190,11 @@ func Run(id string, code string, params string) engine.RunResult {
 	select {
 	case <-ctx.Done():
 		thread.Cancel("")
-		res.Err = fmt.Errorf("execution timeout: %dms", timeout)
+		res.Err = engine.ContextError(parent, budget.Timeout)
+		res.Logs = logs.Lines()
 		return res
 	case res := <-ch:
+		res.Logs = logs.Lines()
 		return res
 	}
 }
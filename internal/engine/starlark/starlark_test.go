@@ -1,15 +1,22 @@
 package starlark_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"service/internal/engine"
 	"service/internal/engine/starlark"
+	"service/internal/utils"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
 
+var ctx = context.Background()
+
 func TestMain(m *testing.M) {
 	viper.SetDefault("timeout", 50)
 
@@ -20,7 +27,7 @@ func TestMain(m *testing.M) {
 func TestParams(t *testing.T) {
 	code := `return p["version_code"]`
 	params := `{"version_code": 1024}`
-	res := starlark.Run("", code, params)
+	res := starlark.Run(ctx, engine.Task{Code: code, Params: params})
 	assert.NoError(t, res.Err, "runner returned an error")
 	assert.Equal(t, "1024", res.Val, "wrong returned result")
 }
@@ -33,7 +40,7 @@ else:
 	return "invalid"
 `
 	params := `{"version_code": 1024}`
-	res := starlark.Run("", code, params)
+	res := starlark.Run(ctx, engine.Task{Code: code, Params: params})
 	assert.NoError(t, res.Err, "runner returned an error")
 	assert.Equal(t, "\"valid\"", res.Val, "wrong returned result")
 }
@@ -49,7 +56,7 @@ def judge(version):
 return judge(p["version_code"])
 `
 	params := `{"version_code": 1024}`
-	res := starlark.Run("", code, params)
+	res := starlark.Run(ctx, engine.Task{Code: code, Params: params})
 	assert.NoError(t, res.Err, "runner returned an error")
 	assert.Equal(t, "True", res.Val, "wrong returned result")
 }
@@ -60,18 +67,31 @@ for i in range(100000000000):
 	pass
 `
 	params := `{}`
-	res := starlark.Run("", code, params)
+	res := starlark.Run(ctx, engine.Task{Code: code, Params: params})
 	// assert.NoError(t, res.Err)
 	assert.Error(t, res.Err, "fail to exit on timeout")
 }
 
+func TestStepBudget(t *testing.T) {
+	code := `
+for i in range(100000000000):
+	pass
+`
+	res := starlark.Run(ctx, engine.Task{Params: "{}", Code: code, Budget: engine.Budget{MaxSteps: 1000}})
+	assert.ErrorIs(t, res.Err, engine.ErrBudgetExceeded, "fail to exit on step limit")
+	assert.Contains(t, res.Err.Error(), "step budget", "wrong exceeded budget")
+
+	res = starlark.Run(ctx, engine.Task{Params: "{}", Code: "return 1", Budget: engine.Budget{MaxSteps: 1000}})
+	assert.NoError(t, res.Err, "runner returned an error")
+}
+
 func TestHitCodeCache(t *testing.T) {
 	for i := 0; i < 2; i++ {
-		res := starlark.Run("id", "", "{}")
+		res := starlark.Run(ctx, engine.Task{Code: "return 1", Params: "{}"})
 		assert.NoError(t, res.Err, "runner returned an error")
 	}
 
-	starlark.ClearCache("id")
+	starlark.ClearCache("return 1")
 }
 
 func TestConcurrentCodeCacheAccess(t *testing.T) {
@@ -79,9 +99,9 @@ func TestConcurrentCodeCacheAccess(t *testing.T) {
 	ch := make(chan int, num)
 	for i := 0; i < num; i++ {
 		go func(i int) {
-			id := fmt.Sprintln(i)
-			starlark.Run(id, "", "{}")
-			starlark.ClearCache(id)
+			code := fmt.Sprintf("return %d", i)
+			starlark.Run(ctx, engine.Task{Code: code, Params: "{}"})
+			starlark.ClearCache(code)
 			ch <- 1
 		}(i)
 	}
@@ -95,3 +115,278 @@ func TestConcurrentCodeCacheAccess(t *testing.T) {
 		}
 	}
 }
+
+func TestValidate(t *testing.T) {
+	assert.Empty(t, starlark.Validate(nil, `return p["version_code"]`))
+	assert.NotEmpty(t, starlark.Validate(nil, `return (`), "syntax error not reported")
+	assert.NotEmpty(t, starlark.Validate(nil, `return undefined_name`), "undefined name not reported")
+
+	errs := starlark.Validate(nil, "x = a\nreturn b")
+	assert.Len(t, errs, 2, "not all errors reported")
+	assert.Equal(t, 2, errs[1].Line, "wrong error line")
+}
+
+func TestCacheStats(t *testing.T) {
+	before := starlark.CacheStats(nil)
+
+	for i := 0; i < 2; i++ {
+		res := starlark.Run(ctx, engine.Task{Code: "return 'stats'", Params: "{}"})
+		assert.NoError(t, res.Err, "runner returned an error")
+	}
+	starlark.ClearCache("return 'stats'")
+
+	after := starlark.CacheStats(nil)
+	assert.Equal(t, before.Hits+1, after.Hits, "wrong number of hits")
+	assert.Equal(t, before.Misses+1, after.Misses, "wrong number of misses")
+}
+
+func TestJSONResult(t *testing.T) {
+	cases := map[string]string{
+		`return "valid"`:               `"valid"`,
+		`return True`:                  `true`,
+		`return p["version_code"]`:     `1024`,
+		`return {"arr": [1, 2.5]}`:     `{"arr":[1,2.5]}`,
+		`pass`:                         `null`,
+		`return [None, "a", False, 0]`: `[null,"a",false,0]`,
+	}
+
+	for code, expected := range cases {
+		res := starlark.Run(ctx, engine.Task{Code: code, Params: `{"version_code": 1024}`})
+		assert.NoError(t, res.Err, "runner returned an error")
+		assert.JSONEq(t, expected, string(res.JSON), "wrong JSON result for %s", code)
+	}
+
+	res := starlark.Run(ctx, engine.Task{Code: `return lambda: 1`, Params: "{}"})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Nil(t, res.JSON, "unserializable result encoded")
+}
+
+func TestCaptureLogs(t *testing.T) {
+	code := `
+print("version", p["version_code"])
+return 1
+`
+	params := `{"version_code": 1024}`
+	res := starlark.Run(ctx, engine.Task{Code: code, Params: params, CaptureLogs: true})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, []string{"version 1024"}, res.Logs, "wrong captured logs")
+
+	res = starlark.Run(ctx, engine.Task{Code: code, Params: params})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Nil(t, res.Logs, "logs not discarded")
+}
+
+func TestErrorPosition(t *testing.T) {
+	cases := []struct {
+		code   string
+		kind   string
+		line   int
+		column int
+	}{
+		{"x = 1\nreturn (", engine.ErrorSyntax, 2, 9},
+		{"x = 1\nreturn y", engine.ErrorSyntax, 2, 8},
+		{"def f(a):\n\treturn 1 // a\n\nreturn f(0)", engine.ErrorRuntime, 2, 11},
+	}
+
+	for _, c := range cases {
+		res := starlark.Run(ctx, engine.Task{Code: c.code, Params: "{}"})
+		err := engine.Describe(res.Err)
+		assert.Equal(t, c.kind, err.Kind, "wrong error kind for %s", c.code)
+		assert.Equal(t, c.line, err.Line, "wrong error line for %s", c.code)
+		assert.Equal(t, c.column, err.Column, "wrong error column for %s", c.code)
+	}
+
+	res := starlark.Run(ctx, engine.Task{Code: "def f(a):\n\treturn 1 // a\n\nreturn f(0)", Params: "{}"})
+	assert.Equal(t, []engine.Frame{
+		{Name: "f", Line: 2, Column: 11},
+		{Name: "<main>", Line: 4, Column: 9},
+	}, engine.Describe(res.Err).Stack, "wrong call stack")
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(5*time.Millisecond, cancel)
+
+	code := `
+for i in range(100000000000):
+	pass
+`
+	res := starlark.Run(ctx, engine.Task{Code: code, Params: "{}"})
+	assert.ErrorIs(t, res.Err, engine.ErrCancelled, "fail to exit on cancellation")
+	assert.Equal(t, engine.ErrorCancelled, engine.Describe(res.Err).Kind)
+}
+
+func TestModules(t *testing.T) {
+	now := time.Date(2022, 5, 1, 8, 0, 0, 0, time.UTC)
+	cases := map[string]string{
+		`return math.floor(2.5)`:                                 `2`,
+		`return time.now().year`:                                 `2022`,
+		`return time.now() == time.now()`:                        `true`,
+		`return struct(a=1).a`:                                   `1`,
+		`return semver.compare("1.10.0", "v1.9")`:                `1`,
+		`return semver.compare("1.0.0-beta.2", "1.0.0-beta.11")`: `-1`,
+		`return semver.compare("1.0.0-rc.1", "1.0.0")`:           `-1`,
+		`return semver.parse("2.1.3-rc.1+b5").prerelease`:        `"rc.1"`,
+		`return semver.valid("1.x")`:                             `false`,
+		`return re.match("^cn-", p["region"])`:                   `true`,
+		`return re.sub("(\\w+)-(\\d+)", "$2", p["region"])`:      `"1"`,
+		`return re.find_all("\\d", "a1b2")`:                      `["1","2"]`,
+	}
+
+	for code, expected := range cases {
+		res := starlark.Run(ctx, engine.Task{Code: code, Params: `{"region": "cn-1"}`, Now: now})
+		assert.NoError(t, res.Err, "runner returned an error for %s", code)
+		assert.JSONEq(t, expected, string(res.JSON), "wrong result for %s", code)
+	}
+}
+
+func TestModuleAllowlist(t *testing.T) {
+	viper.Set("pools.restricted.starlark-modules", []string{"math"})
+	defer viper.Set("pools.restricted.starlark-modules", nil)
+
+	pool := engine.GetPool("restricted")
+	assert.Equal(t, []string{"math"}, starlark.Modules(pool))
+
+	res := starlark.Run(ctx, engine.Task{Code: `return math.pi > 3`, Params: "{}", Pool: pool})
+	assert.NoError(t, res.Err, "allowed module not available")
+
+	res = starlark.Run(ctx, engine.Task{Code: `return time.now()`, Params: "{}", Pool: pool})
+	assert.Equal(t, engine.ErrorSyntax, engine.Describe(res.Err).Kind, "disallowed module available")
+
+	assert.Empty(t, starlark.Validate(pool, `return math.pi > 3`), "allowed module not validated")
+	assert.Empty(t, starlark.Validate(nil, `return time.now()`), "module of the default pool not validated")
+	assert.NotEmpty(t, starlark.Validate(pool, `return time.now()`), "disallowed module validated")
+}
+
+// loader of the modules in the map
+func mapLoader(modules map[string]string) engine.ModuleLoader {
+	return func(lang, name string) (*engine.Module, error) {
+		code, exist := modules[name]
+		if !exist {
+			return nil, fmt.Errorf("%w '%s'", engine.ErrUnknownModule, name)
+		}
+		return &engine.Module{Name: name, Version: 1, Code: code}, nil
+	}
+}
+
+func TestLoadModule(t *testing.T) {
+	loader := mapLoader(map[string]string{
+		"lib/helpers": "load(\"lib/base\", \"base\")\ndef add(x):\n    return x + base\nlist = [1]",
+		"lib/base":    "base = 100",
+		"lib/a":       "load(\"lib/b\", \"b\")\na = 1",
+		"lib/b":       "load(\"lib/a\", \"a\")\nb = 1",
+	})
+
+	code := `
+load("lib/helpers", "add")
+return add(p["version_code"])
+`
+	res := starlark.Run(ctx, engine.Task{Code: code, Params: `{"version_code": 1}`, Modules: loader})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "101", res.Val, "wrong returned result")
+
+	res = starlark.Run(ctx, engine.Task{Code: "load(\"lib/helpers\", \"list\")\nlist.append(2)", Params: "{}", Modules: loader})
+	assert.ErrorContains(t, res.Err, "frozen", "loaded module not frozen")
+
+	res = starlark.Run(ctx, engine.Task{Code: "load(\"lib/a\", \"a\")\nreturn a", Params: "{}", Modules: loader})
+	assert.ErrorContains(t, res.Err, "circular module load: lib/a -> lib/b -> lib/a")
+	assert.Equal(t, 1, engine.Describe(res.Err).Line, "wrong error line")
+
+	res = starlark.Run(ctx, engine.Task{Code: "load(\"lib/none\", \"x\")\nreturn x", Params: "{}", Modules: loader})
+	assert.ErrorContains(t, res.Err, "unknown module 'lib/none'")
+
+	res = starlark.Run(ctx, engine.Task{Code: "load(\"lib/base\", \"base\")\nreturn base", Params: "{}"})
+	assert.ErrorContains(t, res.Err, "unknown module 'lib/base'", "module loaded without a loader")
+
+	assert.Empty(t, starlark.Validate(nil, "load(\"lib/base\", \"base\")\nreturn base"))
+}
+
+func TestMetaAndBucket(t *testing.T) {
+	code := `return [meta["device_id"], bucket(meta["device_id"], "exp", 100)]`
+	meta := `{"platform": "ios", "version": 3, "device_id": "device"}`
+	res := starlark.Run(ctx, engine.Task{Code: code, Params: "{}", Meta: meta})
+	assert.NoError(t, res.Err, "runner returned an error")
+	bucket, _ := utils.Bucket("device", "exp", 100)
+	assert.JSONEq(t, fmt.Sprintf(`["device", %d]`, bucket), string(res.JSON))
+
+	res = starlark.Run(ctx, engine.Task{Code: `return meta`, Params: "{}"})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.JSONEq(t, `{}`, string(res.JSON), "meta not defaulted")
+
+	res = starlark.Run(ctx, engine.Task{Code: `return bucket("a", "b", 0)`, Params: "{}"})
+	assert.ErrorContains(t, res.Err, "n must be a positive integer")
+
+	// out of uint32, not wrapped to 0 buckets
+	res = starlark.Run(ctx, engine.Task{Code: `return bucket("a", "b", 1 << 32)`, Params: "{}"})
+	assert.ErrorContains(t, res.Err, "n must be a positive integer")
+}
+
+// program store in memory
+type mapStore struct {
+	programs map[string][]byte
+	hits     int
+}
+
+func (s *mapStore) Get(key string) ([]byte, error) {
+	data, exist := s.programs[key]
+	if !exist {
+		return nil, errors.New("missing")
+	}
+	s.hits++
+	return data, nil
+}
+
+func (s *mapStore) Set(key string, data []byte) error {
+	s.programs[key] = data
+	return nil
+}
+
+func TestProgramStore(t *testing.T) {
+	store := &mapStore{programs: map[string][]byte{}}
+	code := "def f(a):\n\treturn 1 // a\n\nreturn f(p[\"a\"])"
+
+	res := starlark.Run(ctx, engine.Task{Code: code, Params: `{"a": 1}`, Programs: store})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Len(t, store.programs, 1, "program not stored")
+
+	// corrupt programs are recompiled and replaced
+	starlark.ClearCache(code)
+	for key := range store.programs {
+		store.programs[key] = []byte("corrupt")
+	}
+	res = starlark.Run(ctx, engine.Task{Code: code, Params: `{"a": 1}`, Programs: store})
+	assert.NoError(t, res.Err, "runner returned an error")
+	for _, data := range store.programs {
+		assert.NotEqual(t, "corrupt", string(data), "corrupt program not replaced")
+	}
+
+	// the stored program is used, reporting errors in the user's code
+	starlark.ClearCache(code)
+	hits := store.hits
+	res = starlark.Run(ctx, engine.Task{Code: code, Params: `{"a": 0}`, Programs: store})
+	assert.Equal(t, hits+1, store.hits, "stored program not used")
+	err := engine.Describe(res.Err)
+	assert.Equal(t, engine.ErrorRuntime, err.Kind)
+	assert.Equal(t, 2, err.Line, "wrong error line")
+}
+
+func TestContentAddressedCache(t *testing.T) {
+	before := starlark.CacheStats(nil)
+
+	// identical code shares the compiled program
+	for i := 0; i < 2; i++ {
+		res := starlark.Run(ctx, engine.Task{Code: "return 'v1'", Params: "{}"})
+		assert.Equal(t, `"v1"`, res.Val, "wrong returned result")
+	}
+
+	// edited code is never served stale
+	res := starlark.Run(ctx, engine.Task{Code: "return 'v2'", Params: "{}"})
+	assert.Equal(t, `"v2"`, res.Val, "stale code executed")
+
+	after := starlark.CacheStats(nil)
+	assert.Equal(t, before.Hits+1, after.Hits, "wrong number of hits")
+	assert.Equal(t, before.Misses+2, after.Misses, "wrong number of misses")
+
+	starlark.ClearCache("return 'v1'")
+	starlark.ClearCache("return 'v2'")
+}
@@ -0,0 +1,54 @@
+package starlark
+
+import (
+	"bytes"
+	"log"
+	"service/internal/engine"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// key of the compiled program in the store, depending on the interpreter,
+// the code and the predeclared names it is resolved against
+func programKey(code string, predeclared starlark.StringDict) string {
+	return engine.ContentHash(append([]string{Engine{}.Name(), version, code}, predeclared.Keys()...)...)
+}
+
+// compile the wrapped code, reusing the program in the store if present
+// and storing the newly compiled one otherwise
+func compile(store engine.ProgramStore, code string, predeclared starlark.StringDict) (*starlark.Program, error) {
+	var key string
+
+	if store != nil {
+		key = programKey(code, predeclared)
+		if data, err := store.Get(key); err == nil {
+			// programs written by an incompatible version are recompiled
+			if program, err := starlark.CompiledProgram(bytes.NewReader(data)); err == nil {
+				return program, nil
+			}
+		}
+	}
+
+	file, err := syntax.Parse(fileName, code, 0)
+	if err != nil {
+		return nil, err
+	}
+	hoistLoads(file)
+
+	program, err := starlark.FileProgram(file, predeclared.Has)
+	if err != nil {
+		return nil, err
+	}
+
+	if store != nil {
+		var buf bytes.Buffer
+		if err := program.Write(&buf); err != nil {
+			log.Print(err)
+		} else if err := store.Set(key, buf.Bytes()); err != nil {
+			log.Print(err)
+		}
+	}
+
+	return program, nil
+}
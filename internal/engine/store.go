@@ -0,0 +1,25 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// ProgramStore persists compiled programs, so that they are shared by
+// all instances of the service.
+type ProgramStore interface {
+	// Get returns the program stored with the key, or an error if missing
+	Get(key string) ([]byte, error)
+	Set(key string, data []byte) error
+}
+
+// ContentHash returns the hex encoded SHA-256 hash of the parts.
+func ContentHash(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		// separate the parts so that different splits hash differently
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,30 @@
+package engine
+
+import "runtime/debug"
+
+// ModuleVersion returns the version of the Go module with the path linked
+// into the binary, or "unknown" if not found.
+func ModuleVersion(path string) string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path == path {
+			if dep.Replace != nil {
+				return dep.Replace.Path + "@" + dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+
+	return "unknown"
+}
+
+// CacheKey returns the key of the compiled code in caches, derived from the
+// content so that edited code is never served stale and identical code is
+// compiled once.
+func CacheKey(lang string, version string, code string) string {
+	return ContentHash(lang, version, code)
+}
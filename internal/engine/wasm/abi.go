@@ -0,0 +1,145 @@
+package wasm
+
+import (
+	"context"
+	"fmt"
+	"service/internal/engine"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// The code is a WebAssembly module encoded in base64, which exports:
+//
+//	memory                               the linear memory
+//	alloc(size i32) -> i32               allocates size bytes
+//	run(p_ptr, p_len, m_ptr, m_len i32) -> i64
+//
+// The host writes the params and config meta JSON into memory allocated
+// by alloc, then calls run, which returns the address of the result JSON
+// in the high 32 bits and its length in the low 32 bits. The module may
+// only import the functions of the host module below.
+
+// name of the host module
+const hostModule = "env"
+
+const (
+	exportMemory = "memory"
+	exportAlloc  = "alloc"
+	exportRun    = "run"
+)
+
+var (
+	i32 = api.ValueTypeI32
+	i64 = api.ValueTypeI64
+)
+
+type signature struct {
+	params  []api.ValueType
+	results []api.ValueType
+}
+
+func (s signature) matches(def api.FunctionDefinition) bool {
+	return equalTypes(s.params, def.ParamTypes()) && equalTypes(s.results, def.ResultTypes())
+}
+
+func (s signature) String() string {
+	return fmt.Sprintf("(%s) -> (%s)", typeNames(s.params), typeNames(s.results))
+}
+
+func typeNames(types []api.ValueType) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = api.ValueTypeName(t)
+	}
+	return strings.Join(names, ", ")
+}
+
+func equalTypes(a, b []api.ValueType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// functions the module must export
+var exports = map[string]signature{
+	exportAlloc: {[]api.ValueType{i32}, []api.ValueType{i32}},
+	exportRun:   {[]api.ValueType{i32, i32, i32, i32}, []api.ValueType{i64}},
+}
+
+// functions the module may import from the host
+var imports = map[string]signature{
+	// log(ptr, len i32) prints the string in memory
+	"log": {[]api.ValueType{i32, i32}, nil},
+}
+
+// key of the log buffer of the run in the context
+type logsKey struct{}
+
+// instantiate the host module into the runtime
+func instantiateHost(ctx context.Context, runtime wazero.Runtime) error {
+	_, err := runtime.NewHostModuleBuilder(hostModule).
+		NewFunctionBuilder().WithFunc(hostLog).Export("log").
+		Instantiate(ctx)
+	return err
+}
+
+func hostLog(ctx context.Context, m api.Module, ptr, size uint32) {
+	data, ok := m.Memory().Read(ptr, size)
+	if !ok {
+		panic(fmt.Errorf("log: out of bounds memory access at %d", ptr))
+	}
+
+	logs, _ := ctx.Value(logsKey{}).(*engine.LogBuffer)
+	logs.Print(string(data))
+}
+
+// checkABI checks the imports and exports of the module, returning all
+// errors found
+func checkABI(compiled wazero.CompiledModule) []*engine.ExecError {
+	var errs []*engine.ExecError
+	report := func(format string, args ...interface{}) {
+		errs = append(errs, &engine.ExecError{
+			Kind:    engine.ErrorSyntax,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	for _, def := range compiled.ImportedFunctions() {
+		module, name, _ := def.Import()
+		sig, exist := imports[name]
+		if module != hostModule || !exist {
+			report("unknown import %s.%s", module, name)
+		} else if !sig.matches(def) {
+			report("import %s.%s must be of type %s", module, name, sig)
+		}
+	}
+
+	for _, def := range compiled.ImportedMemories() {
+		module, name, _ := def.Import()
+		report("unknown import %s.%s", module, name)
+	}
+
+	if _, exist := compiled.ExportedMemories()[exportMemory]; !exist {
+		report("missing export %s", exportMemory)
+	}
+
+	defs := compiled.ExportedFunctions()
+	for _, name := range []string{exportAlloc, exportRun} {
+		def, exist := defs[name]
+		if !exist {
+			report("missing export %s", name)
+		} else if sig := exports[name]; !sig.matches(def) {
+			report("export %s must be of type %s", name, sig)
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,291 @@
+// Package wasm executes WebAssembly modules with wazero, a runtime without
+// cgo. Executions are limited by the timeout of the budget and by the
+// memory pages of `wasm-memory-pages`; steps and depth budgets are not
+// supported.
+package wasm
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"service/internal/engine"
+	"service/internal/engine/cache"
+	"strings"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// default memory limit in 64 KiB pages, i.e. 16 MiB
+const defaultMemoryPages = 256
+
+var errBadResult = errors.New("run returned an out of bounds result")
+
+// instances are anonymous so that a module can be instantiated concurrently
+var moduleConfig = wazero.NewModuleConfig().WithName("").WithStartFunctions()
+
+// runtime holds the modules compiled for a pool. Modules are closed when
+// evicted from the cache, so the lock is held for reading while modules
+// are instantiated and for writing while the cache is changed.
+type runtime struct {
+	mu       sync.RWMutex
+	runtime  wazero.Runtime
+	compiled *cache.LRU[wazero.CompiledModule]
+	err      error // error creating the runtime
+}
+
+// runtimes of each pool
+var runtimes = engine.NewPoolLocal(func(pool *engine.Pool) *runtime {
+	pages := pool.GetInt("wasm-memory-pages")
+	if pages <= 0 {
+		pages = defaultMemoryPages
+	}
+
+	ctx := context.Background()
+	r := &runtime{
+		runtime: wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+			WithCloseOnContextDone(true).
+			WithMemoryLimitPages(uint32(pages))),
+		// compiled modules never go stale, and expiring them while
+		// reading the cache would close modules being instantiated
+		compiled: cache.New[wazero.CompiledModule](pool.GetInt("code-cache-size"), 0),
+	}
+
+	r.compiled.OnEvict(func(compiled wazero.CompiledModule) {
+		compiled.Close(ctx)
+	})
+	r.err = instantiateHost(ctx, r.runtime)
+
+	return r
+})
+
+type Engine struct{}
+
+func init() {
+	engine.Register(Engine{})
+}
+
+func (Engine) Name() string {
+	return "wasm"
+}
+
+func (Engine) Run(ctx context.Context, task engine.Task) engine.RunResult {
+	return Run(ctx, task)
+}
+
+func (Engine) Validate(pool *engine.Pool, code string) []*engine.ExecError {
+	return Validate(code)
+}
+
+func (Engine) ClearCache(code string) {
+	ClearCache(code)
+}
+
+func (Engine) CacheStats(pool *engine.Pool) cache.Stats {
+	return CacheStats(pool)
+}
+
+// version of the runtime, part of the compiled code cache keys
+var version = engine.ModuleVersion("github.com/tetratelabs/wazero")
+
+func cacheKey(code string) string {
+	return engine.CacheKey(Engine{}.Name(), version, code)
+}
+
+func ClearCache(code string) {
+	key := cacheKey(code)
+	for _, r := range runtimes.All() {
+		r.mu.Lock()
+		r.compiled.Remove(key)
+		r.mu.Unlock()
+	}
+}
+
+func CacheStats(pool *engine.Pool) cache.Stats {
+	return runtimes.Get(pool).compiled.Stats()
+}
+
+// decode the base64 module, ignoring white spaces
+func decode(code string) ([]byte, error) {
+	code = strings.Join(strings.Fields(code), "")
+	bin, err := base64.StdEncoding.DecodeString(code)
+	if err != nil {
+		return nil, &engine.ExecError{
+			Kind:    engine.ErrorSyntax,
+			Message: "invalid base64 module: " + err.Error(),
+		}
+	}
+	return bin, nil
+}
+
+// compile the module and check its ABI, returning all errors found
+func compile(ctx context.Context, runtime wazero.Runtime, code string) (wazero.CompiledModule, []*engine.ExecError) {
+	bin, err := decode(code)
+	if err != nil {
+		return nil, []*engine.ExecError{engine.Describe(err)}
+	}
+
+	compiled, err := runtime.CompileModule(ctx, bin)
+	if err != nil {
+		return nil, []*engine.ExecError{{
+			Kind:    engine.ErrorSyntax,
+			Message: err.Error(),
+		}}
+	}
+
+	if errs := checkABI(compiled); errs != nil {
+		compiled.Close(ctx)
+		return nil, errs
+	}
+
+	return compiled, nil
+}
+
+// Validate decodes and compiles the module and checks its ABI, returning
+// all errors found.
+func Validate(code string) []*engine.ExecError {
+	ctx := context.Background()
+
+	// compiled modules are shared within a runtime
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfigInterpreter())
+	defer runtime.Close(ctx)
+
+	compiled, errs := compile(ctx, runtime, code)
+	if errs != nil {
+		return errs
+	}
+	compiled.Close(ctx)
+
+	return nil
+}
+
+// instantiate the module, compiled or from cache
+func (r *runtime) instantiate(ctx context.Context, code string) (api.Module, error) {
+	key := cacheKey(code)
+
+	r.mu.RLock()
+	if compiled, cacheExist := r.compiled.Get(key); cacheExist {
+		defer r.mu.RUnlock()
+		return r.runtime.InstantiateModule(ctx, compiled, moduleConfig)
+	}
+	r.mu.RUnlock()
+
+	// compile without blocking the runs of other modules
+	compiled, errs := compile(ctx, r.runtime, code)
+	if errs != nil {
+		return nil, errs[0]
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// the module may be compiled by a concurrent run meanwhile, and a
+	// replaced module is not closed on eviction
+	if cached, cacheExist := r.compiled.GetOrAdd(key, compiled); cacheExist {
+		compiled.Close(ctx)
+		compiled = cached
+	}
+
+	return r.runtime.InstantiateModule(ctx, compiled, moduleConfig)
+}
+
+// write the data into memory allocated by the module
+func write(ctx context.Context, mod api.Module, data string) (uint32, error) {
+	ret, err := mod.ExportedFunction(exportAlloc).Call(ctx, uint64(len(data)))
+	if err != nil {
+		return 0, err
+	}
+
+	ptr := uint32(ret[0])
+	if !mod.ExportedMemory(exportMemory).Write(ptr, []byte(data)) {
+		return 0, fmt.Errorf("alloc returned an out of bounds address %d", ptr)
+	}
+
+	return ptr, nil
+}
+
+// call run with the params and meta, returning the result JSON
+func call(ctx context.Context, mod api.Module, task engine.Task) ([]byte, error) {
+	params, err := write(ctx, mod, task.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := write(ctx, mod, task.MetaJSON())
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := mod.ExportedFunction(exportRun).Call(ctx,
+		uint64(params), uint64(len(task.Params)),
+		uint64(meta), uint64(len(task.MetaJSON())))
+	if err != nil {
+		return nil, err
+	}
+
+	data, ok := mod.ExportedMemory(exportMemory).Read(uint32(ret[0]>>32), uint32(ret[0]))
+	if !ok {
+		return nil, errBadResult
+	}
+
+	// copy out of the memory closed with the module
+	return append([]byte(nil), data...), nil
+}
+
+// translate errors from the runtime, keeping the first line of traps
+func translateError(err error) error {
+	var execErr *engine.ExecError
+	if errors.As(err, &execErr) {
+		return err
+	}
+
+	msg, _, _ := strings.Cut(err.Error(), "\n")
+	return &engine.ExecError{Kind: engine.ErrorRuntime, Message: msg}
+}
+
+func Run(parent context.Context, task engine.Task) engine.RunResult {
+	budget := task.Budget.Effective(task.Pool)
+	r := runtimes.Get(task.Pool)
+	if r.err != nil {
+		return engine.RunResult{Err: r.err}
+	}
+
+	logs := engine.NewLogBuffer(task.CaptureLogs)
+
+	ctx, cancel := context.WithTimeout(parent, budget.Timeout)
+	defer cancel()
+	ctx = context.WithValue(ctx, logsKey{}, logs)
+
+	res := engine.RunResult{}
+
+	mod, err := r.instantiate(ctx, task.Code)
+	if err == nil {
+		defer mod.Close(context.Background())
+
+		var data []byte
+		if data, err = call(ctx, mod, task); err == nil {
+			res.Val = string(data)
+			if json.Valid(data) {
+				res.JSON = data
+			}
+
+			// strings without quotes as other engines
+			var str string
+			if json.Unmarshal(data, &str) == nil {
+				res.Val = str
+			}
+		}
+	}
+
+	if err != nil && ctx.Err() != nil {
+		res.Err = engine.ContextError(parent, budget.Timeout)
+	} else if err != nil {
+		res.Err = translateError(err)
+	}
+
+	res.Logs = logs.Lines()
+	return res
+}
@@ -0,0 +1,177 @@
+package wasm_test
+
+import (
+	"context"
+	"os"
+	"service/internal/engine"
+	"service/internal/engine/wasm"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+var ctx = context.Background()
+
+// modules exporting memory, alloc bumping a pointer from 1024, and run:
+//
+//	echo: logs the params with env.log and returns them
+//	meta: returns the meta
+//	loop: loops forever
+//	trap: executes unreachable
+const (
+	echoModule = "AGFzbQEAAAABEwNgAn9/AGABfwF/YAR/f39/AX4CCwEDZW52A2xvZwAAAwMCAQIFAwEAAQYHAX8BQYAICwcYAwZtZW1vcnkCAAVhbGxvYwABA3J1bgACCiACCwAjACMAIABqJAALEgAgACABEAAgAK1CIIYgAa2ECw=="
+	metaModule = "AGFzbQEAAAABEwNgAn9/AGABfwF/YAR/f39/AX4DAwIBAgUDAQABBgcBfwFBgAgLBxgDBm1lbW9yeQIABWFsbG9jAAADcnVuAAEKGgILACMAIwAgAGokAAsMACACrUIghiADrYQL"
+	loopModule = "AGFzbQEAAAABEwNgAn9/AGABfwF/YAR/f39/AX4DAwIBAgUDAQABBgcBfwFBgAgLBxgDBm1lbW9yeQIABWFsbG9jAAADcnVuAAEKFwILACMAIwAgAGokAAsJAANADAALQgAL"
+	trapModule = "AGFzbQEAAAABEwNgAn9/AGABfwF/YAR/f39/AX4DAwIBAgUDAQABBgcBfwFBgAgLBxgDBm1lbW9yeQIABWFsbG9jAAADcnVuAAEKEQILACMAIwAgAGokAAsDAAAL"
+)
+
+// meta module with 2 pages of memory
+const largeModule = "AGFzbQEAAAABEwNgAn9/AGABfwF/YAR/f39/AX4DAwIBAgUDAQACBgcBfwFBgAgLBxgDBm1lbW9yeQIABWFsbG9jAAADcnVuAAEKGgILACMAIwAgAGokAAsMACACrUIghiADrYQL"
+
+// meta module importing env.open
+const badImportModule = "AGFzbQEAAAABEwNgAn9/AGABfwF/YAR/f39/AX4CDAEDZW52BG9wZW4AAAMDAgECBQMBAAEGBwF/AUGACAsHGAMGbWVtb3J5AgAFYWxsb2MAAQNydW4AAgoaAgsAIwAjACAAaiQACwwAIAKtQiCGIAOthAs="
+
+func TestMain(m *testing.M) {
+	viper.SetDefault("timeout", 50)
+
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestParams(t *testing.T) {
+	params := `{"version_code": 1024}`
+	res := wasm.Run(ctx, engine.Task{Code: echoModule, Params: params, CaptureLogs: true})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.JSONEq(t, params, string(res.JSON), "wrong returned result")
+	assert.Equal(t, []string{params}, res.Logs, "wrong logs")
+
+	// legacy string representation
+	res = wasm.Run(ctx, engine.Task{Code: echoModule, Params: `"valid"`})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.Equal(t, "valid", res.Val, "wrong returned result")
+}
+
+func TestMeta(t *testing.T) {
+	meta := `{"platform": "ios", "version": 3}`
+	res := wasm.Run(ctx, engine.Task{Code: metaModule, Params: "{}", Meta: meta})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.JSONEq(t, meta, string(res.JSON), "wrong returned result")
+
+	res = wasm.Run(ctx, engine.Task{Code: metaModule, Params: "{}"})
+	assert.NoError(t, res.Err, "runner returned an error")
+	assert.JSONEq(t, `{}`, string(res.JSON), "meta not defaulted")
+}
+
+func TestTimeout(t *testing.T) {
+	res := wasm.Run(ctx, engine.Task{Code: loopModule, Params: "{}"})
+	assert.ErrorIs(t, res.Err, engine.ErrBudgetExceeded, "fail to exit on timeout")
+
+	// the module is still usable
+	res = wasm.Run(ctx, engine.Task{Code: echoModule, Params: "{}"})
+	assert.NoError(t, res.Err, "runner returned an error")
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(5*time.Millisecond, cancel)
+
+	res := wasm.Run(ctx, engine.Task{Code: loopModule, Params: "{}"})
+	assert.ErrorIs(t, res.Err, engine.ErrCancelled, "fail to exit on cancellation")
+}
+
+func TestTrap(t *testing.T) {
+	res := wasm.Run(ctx, engine.Task{Code: trapModule, Params: "{}"})
+	err := engine.Describe(res.Err)
+	assert.Equal(t, engine.ErrorRuntime, err.Kind, "wrong error kind")
+	assert.Contains(t, err.Message, "unreachable", "wrong error message")
+}
+
+func TestMemoryLimit(t *testing.T) {
+	viper.Set("pools.small.wasm-memory-pages", 1)
+	defer viper.Set("pools.small.wasm-memory-pages", nil)
+	pool := engine.GetPool("small")
+
+	res := wasm.Run(ctx, engine.Task{Code: metaModule, Params: "{}", Pool: pool})
+	assert.NoError(t, res.Err, "runner returned an error")
+
+	res = wasm.Run(ctx, engine.Task{Code: largeModule, Params: "{}", Pool: pool})
+	assert.Error(t, res.Err, "memory limit not respected")
+
+	res = wasm.Run(ctx, engine.Task{Code: largeModule, Params: "{}"})
+	assert.NoError(t, res.Err, "runner returned an error")
+}
+
+func TestValidate(t *testing.T) {
+	assert.Empty(t, wasm.Validate(echoModule))
+	assert.NotEmpty(t, wasm.Validate("not base64!"), "invalid base64 not reported")
+	assert.NotEmpty(t, wasm.Validate("AGFzbQ=="), "invalid module not reported")
+
+	errs := wasm.Validate(badImportModule)
+	if assert.Len(t, errs, 1, "unknown import not reported") {
+		assert.Equal(t, "unknown import env.open", errs[0].Message, "wrong error message")
+	}
+
+	res := wasm.Run(ctx, engine.Task{Code: badImportModule, Params: "{}"})
+	assert.Equal(t, engine.ErrorSyntax, engine.Describe(res.Err).Kind, "wrong error kind")
+}
+
+func TestCacheStats(t *testing.T) {
+	wasm.ClearCache(metaModule)
+	before := wasm.CacheStats(nil)
+
+	for i := 0; i < 2; i++ {
+		res := wasm.Run(ctx, engine.Task{Code: metaModule, Params: "{}"})
+		assert.NoError(t, res.Err, "runner returned an error")
+	}
+	wasm.ClearCache(metaModule)
+
+	// compiled again after removed
+	res := wasm.Run(ctx, engine.Task{Code: metaModule, Params: "{}"})
+	assert.NoError(t, res.Err, "runner returned an error")
+	wasm.ClearCache(metaModule)
+
+	after := wasm.CacheStats(nil)
+	assert.Equal(t, before.Hits+1, after.Hits, "wrong number of hits")
+	assert.Equal(t, before.Misses+2, after.Misses, "wrong number of misses")
+}
+
+func TestConcurrentRun(t *testing.T) {
+	const num = 100
+	ch := make(chan engine.RunResult, num)
+	for i := 0; i < num; i++ {
+		go func() {
+			ch <- wasm.Run(ctx, engine.Task{Code: echoModule, Params: "[1]"})
+		}()
+	}
+
+	for i := 0; i < num; i++ {
+		res := <-ch
+		assert.NoError(t, res.Err, "runner returned an error")
+	}
+}
+
+func TestConcurrentCompile(t *testing.T) {
+	wasm.ClearCache(metaModule)
+	before := wasm.CacheStats(nil)
+
+	// runs missing the cache compile concurrently, and share one module
+	const num = 10
+	ch := make(chan engine.RunResult, num)
+	for i := 0; i < num; i++ {
+		go func() {
+			ch <- wasm.Run(ctx, engine.Task{Code: metaModule, Params: "{}"})
+		}()
+	}
+
+	for i := 0; i < num; i++ {
+		res := <-ch
+		assert.NoError(t, res.Err, "runner returned an error")
+	}
+
+	after := wasm.CacheStats(nil)
+	assert.Equal(t, before.Size+1, after.Size, "module cached more than once")
+	assert.Equal(t, before.Hits+before.Misses+num, after.Hits+after.Misses, "lookups counted more than once")
+	wasm.ClearCache(metaModule)
+}
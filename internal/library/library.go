@@ -0,0 +1,78 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"service/internal/engine"
+	"service/internal/model"
+	"service/internal/redis"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+func getLibraryCacheKey(lang string, name string) string {
+	return "library/" + lang + "/" + name
+}
+
+// get the library with the name, which is suffixed with `@<version>`
+// if a version is pinned
+func getLibrary(lang string, name string, cached bool) (model.Library, error) {
+	cacheKey := getLibraryCacheKey(lang, name)
+
+	// check redis
+	if cached {
+		if library, err := redis.Get[model.Library](cacheKey); err == nil || err != redis.ErrGet {
+			return *library, err
+		}
+	}
+
+	// no-cached, cache miss or error occurs
+	var library model.Library
+	query := model.DB.Where("name = ? AND lang = ?", name, lang)
+
+	if path, version, pinned := strings.Cut(name, "@"); pinned {
+		number, err := strconv.Atoi(version)
+		if err != nil {
+			return library, fmt.Errorf("invalid version '%s'", version)
+		}
+		query = model.DB.Where("name = ? AND lang = ? AND version = ?", path, lang, number)
+	}
+
+	if err := query.Order("version desc").First(&library).Error; err != nil {
+		return library, err
+	}
+
+	if cached {
+		// cache the library in redis
+		expiration := viper.GetDuration("redis-expiration")
+		if err := redis.Set(cacheKey, library, expiration); err != nil {
+			log.Print(err)
+		}
+	}
+
+	return library, nil
+}
+
+// Loader returns the loader of the modules in the library table, which are
+// cached in redis if cached is true.
+func Loader(cached bool) engine.ModuleLoader {
+	return func(lang string, name string) (*engine.Module, error) {
+		library, err := getLibrary(lang, name, cached)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w '%s'", engine.ErrUnknownModule, name)
+		} else if err != nil {
+			return nil, err
+		}
+
+		return &engine.Module{
+			Lang:    library.Lang,
+			Name:    library.Name,
+			Version: library.Version,
+			Code:    library.Content,
+		}, nil
+	}
+}
@@ -0,0 +1,80 @@
+package library_test
+
+import (
+	"os"
+	"service/internal/engine"
+	"service/internal/library"
+	"service/internal/model"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+var mock sqlmock.Sqlmock
+
+func TestMain(m *testing.M) {
+	db, sqlMock, err := sqlmock.New()
+	if err != nil {
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	mock = sqlMock
+
+	model.DB, err = gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+
+	if err != nil {
+		os.Exit(1)
+	}
+
+	m.Run()
+}
+
+func libraryRow(library model.Library) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "name", "version", "lang", "code"}).
+		AddRow(library.ID, library.Name, library.Version, library.Lang, library.Content)
+}
+
+func TestLoadLatest(t *testing.T) {
+	mock.ExpectQuery("SELECT (.+) FROM `library` WHERE name = (.+) AND lang = (.+) ORDER BY version desc").
+		WithArgs("lib/helpers", "starlark").
+		WillReturnRows(libraryRow(model.Library{
+			ID: 1, Name: "lib/helpers", Version: 3, Lang: "starlark", Content: "x = 1",
+		}))
+
+	module, err := library.Loader(false)("starlark", "lib/helpers")
+	assert.NoError(t, err)
+	assert.Equal(t, &engine.Module{Lang: "starlark", Name: "lib/helpers", Version: 3, Code: "x = 1"}, module)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoadPinned(t *testing.T) {
+	mock.ExpectQuery("SELECT (.+) FROM `library` WHERE name = (.+) AND lang = (.+) AND version = (.+)").
+		WithArgs("lib/helpers", "javascript", 2).
+		WillReturnRows(libraryRow(model.Library{
+			ID: 2, Name: "lib/helpers", Version: 2, Lang: "javascript", Content: "exports.x = 1",
+		}))
+
+	module, err := library.Loader(false)("javascript", "lib/helpers@2")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, module.Version, "wrong module version")
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	_, err = library.Loader(false)("javascript", "lib/helpers@latest")
+	assert.ErrorContains(t, err, "invalid version")
+}
+
+func TestLoadUnknown(t *testing.T) {
+	mock.ExpectQuery("SELECT (.+) FROM `library`").
+		WithArgs("lib/none", "starlark").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	_, err := library.Loader(false)("starlark", "lib/none")
+	assert.ErrorIs(t, err, engine.ErrUnknownModule)
+}
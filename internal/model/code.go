@@ -16,6 +16,14 @@ type Code struct {
 	Params  ParamArray        `gorm:"column:params;<-:false"`
 	Content string            `gorm:"column:code;<-:false"`
 
+	// optional execution budgets, zero for the global limits
+	Timeout  int    `gorm:"column:timeout;<-:false"` // in milliseconds
+	MaxSteps uint64 `gorm:"column:max_steps;<-:false"`
+	MaxDepth int    `gorm:"column:max_depth;<-:false"`
+
+	// seconds to memoize the results by params and meta, zero to disable
+	ResultTTL int `gorm:"column:result_ttl;<-:false"`
+
 	// following fields are for error control
 	IsBroken     bool          `gorm:"column:is_broken;default:false"`
 	ErrorCount   int           `gorm:"column:err_count;default:0"`
@@ -108,23 +116,8 @@ func (code *Code) ValidateParams(params map[string]interface{}) (map[string]inte
 			return ret, fmt.Errorf("missing key '%s' in parameters", param.Name)
 		}
 
-		var targetType reflect.Kind
-
-		switch param.Type {
-		case "string":
-			targetType = reflect.String
-		case "int":
-			// json parser parses int into float
-			targetType = reflect.Float64
-		case "float":
-			targetType = reflect.Float64
-		case "bool":
-			targetType = reflect.Bool
-		case "array":
-			targetType = reflect.Slice
-		case "dictionary":
-			targetType = reflect.Map
-		default:
+		targetType, ok := paramKinds[param.Type]
+		if !ok {
 			return ret, fmt.Errorf("unexpedted type '%s' for param '%s'", param.Type, param.Name)
 		}
 
@@ -0,0 +1,15 @@
+package model
+
+// Library is a versioned module shared by the code of configs, loaded
+// with `load` in Starlark and `require` in JavaScript.
+type Library struct {
+	ID      int    `gorm:"column:id;primaryKey;<-:false"`
+	Name    string `gorm:"column:name;<-:false"`
+	Version int    `gorm:"column:version;<-:false"`
+	Lang    string `gorm:"column:lang;<-:false"`
+	Content string `gorm:"column:code;<-:false"`
+}
+
+func (Library) TableName() string {
+	return "library"
+}
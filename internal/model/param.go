@@ -4,8 +4,22 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"reflect"
+	"service/internal/engine"
 )
 
+// kinds of the decoded JSON values of each parameter type
+var paramKinds = map[string]reflect.Kind{
+	"string": reflect.String,
+	// json parser parses int into float
+	"int":        reflect.Float64,
+	"float":      reflect.Float64,
+	"bool":       reflect.Bool,
+	"array":      reflect.Slice,
+	"dictionary": reflect.Map,
+}
+
 type Param struct {
 	Name string `json:"name"`
 	Type string `json:"type"`
@@ -25,3 +39,33 @@ func (params *ParamArray) Scan(value interface{}) error {
 func (params *ParamArray) Value() (driver.Value, error) {
 	return json.Marshal(params)
 }
+
+// Check checks the declarations of the parameters, returning all errors found.
+func (params ParamArray) Check() []error {
+	var errs []error
+	names := map[string]bool{}
+
+	for _, param := range params {
+		if param.Name == "" {
+			errs = append(errs, errors.New("empty parameter name"))
+		} else if names[param.Name] {
+			errs = append(errs, fmt.Errorf("duplicated parameter '%s'", param.Name))
+		}
+		names[param.Name] = true
+
+		if _, ok := paramKinds[param.Type]; !ok {
+			errs = append(errs, fmt.Errorf("unexpedted type '%s' for param '%s'", param.Type, param.Name))
+		}
+	}
+
+	return errs
+}
+
+// Decls returns the declarations passed to the engines.
+func (params ParamArray) Decls() []engine.ParamDecl {
+	decls := make([]engine.ParamDecl, len(params))
+	for i, param := range params {
+		decls[i] = engine.ParamDecl{Name: param.Name, Type: param.Type}
+	}
+	return decls
+}
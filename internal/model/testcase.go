@@ -7,6 +7,8 @@ type TestCase struct {
 	Input  string `gorm:"column:input;<-:false"`
 	Output string `gorm:"column:output;<-:false"`
 	CodeID string `gorm:"column:code_id;<-:false"`
+	// optional JSON encoded ConfigMeta passed to the code
+	Meta string `gorm:"column:meta;<-:false"`
 }
 
 func (TestCase) TableName() string {
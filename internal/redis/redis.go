@@ -51,3 +51,41 @@ func GetString(key string) (string, error) {
 	val, err := Client.Get(ctx, key).Result()
 	return val, err
 }
+
+// MGet gets the values of the keys with a single command, with nil for
+// missing or undecodable values
+func MGet[T interface{}](keys []string) ([]*T, error) {
+	vals, err := Client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, ErrGet
+	}
+
+	values := make([]*T, len(keys))
+	for i, val := range vals {
+		str, ok := val.(string)
+		if !ok {
+			continue
+		}
+
+		var value T
+		if json.Unmarshal([]byte(str), &value) == nil {
+			values[i] = &value
+		}
+	}
+	return values, nil
+}
+
+// SetMany sets the values of the keys in a single pipeline
+func SetMany(keys []string, values []interface{}, expiration time.Duration) error {
+	_, err := Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			data, err := json.Marshal(values[i])
+			if err != nil {
+				return err
+			}
+			pipe.Set(ctx, key, string(data), expiration)
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,242 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"service/internal/engine"
+	"service/internal/model"
+	"service/internal/redis"
+	"service/internal/router/resp"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// default maximum number of configs of a batch
+const defaultMaxBatchSize = 100
+
+type GetConfigsBody struct {
+	Meta   model.ConfigMeta `json:"meta"`
+	Cached bool             `json:"cached"`
+	// params of each config, keyed by config id
+	Configs         map[string]map[string]interface{} `json:"configs"`
+	ResponseVersion int                               `json:"response_version"`
+}
+
+// ConfigResult is the result of a config in a batch, with the status and
+// message of the error if failed
+type ConfigResult struct {
+	Result interface{} `json:"result"`
+	CodeID string      `json:"code_id,omitempty"`
+	ETag   string      `json:"etag,omitempty"`
+	// served from the result cache
+	FromCache bool              `json:"from_cache,omitempty"`
+	Status    int               `json:"status"`
+	Error     string            `json:"error,omitempty"`
+	Details   *engine.ExecError `json:"details,omitempty"`
+	// seconds to wait before retrying, if rejected as the service is busy
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// GetConfigs evaluates the configs concurrently with the same meta. Failed
+// configs are reported in their results without failing the batch.
+func GetConfigs(c *gin.Context) {
+	body := GetConfigsBody{}
+	if err := c.ShouldBindJSON(&body); err != nil || len(body.Configs) == 0 {
+		resp.Error(c, http.StatusBadRequest, "invalid arguments")
+		return
+	}
+
+	maxBatchSize := viper.GetInt("max-batch-size")
+	if maxBatchSize <= 0 {
+		maxBatchSize = defaultMaxBatchSize
+	}
+	if len(body.Configs) > maxBatchSize {
+		resp.Error(c, http.StatusBadRequest,
+			fmt.Sprintf("too many configs, at most %d in a batch", maxBatchSize))
+		return
+	}
+
+	ids := make([]string, 0, len(body.Configs))
+	for id := range body.Configs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	source := loadBatch(ids, body.Meta, body.Cached)
+
+	// executions are admitted by the engine pool as single requests
+	results := make([]ConfigResult, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+
+			res, err := evaluate(c.Request.Context(), id, GetConfigBody{
+				Meta:            body.Meta,
+				Cached:          body.Cached,
+				Params:          body.Configs[id],
+				ResponseVersion: body.ResponseVersion,
+			}, source)
+
+			if err != nil {
+				results[i] = ConfigResult{Status: err.status, Error: err.msg, Details: err.details}
+				if errors.Is(err, engine.ErrOverloaded) {
+					results[i].RetryAfter = retryAfter()
+				}
+			} else {
+				results[i] = ConfigResult{
+					Result:    res.Result,
+					CodeID:    res.CodeID,
+					ETag:      res.ETag(),
+					FromCache: res.FromCache,
+					Status:    http.StatusOK,
+				}
+			}
+		}(i, id)
+	}
+	wg.Wait()
+
+	configs := make(map[string]ConfigResult, len(ids))
+	for i, id := range ids {
+		configs[id] = results[i]
+
+		// ask the client to retry the rejected configs later
+		if results[i].RetryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(results[i].RetryAfter))
+		}
+	}
+
+	resp.Ok(c, http.StatusOK, map[string]interface{}{
+		"configs": configs,
+	})
+}
+
+// batchSource holds the configs and codes of a batch, loaded at once
+type batchSource struct {
+	configs map[string]model.Config
+	codes   map[string]model.Code
+	err     error // error loading the records
+}
+
+func (s *batchSource) config(configId string) (model.Config, error) {
+	if config, exist := s.configs[configId]; exist {
+		return config, nil
+	}
+	if s.err != nil {
+		return model.Config{}, s.err
+	}
+	return model.Config{}, gorm.ErrRecordNotFound
+}
+
+func (s *batchSource) code(codeId string) (model.Code, error) {
+	if code, exist := s.codes[codeId]; exist {
+		return code, nil
+	}
+	if s.err != nil {
+		return model.Code{}, s.err
+	}
+	return model.Code{}, gorm.ErrRecordNotFound
+}
+
+// loadBatch loads the configs, and the codes they may use for the meta
+func loadBatch(ids []string, meta model.ConfigMeta, cached bool) *batchSource {
+	s := &batchSource{}
+	s.configs, s.err = getRecords(ids, cached, getConfigCacheKey, "config_id",
+		func(config model.Config) string { return config.ConfigID })
+
+	var codeIds []string
+	seen := make(map[string]bool)
+	addCode := func(codeId string) {
+		if codeId != "" && !seen[codeId] {
+			seen[codeId] = true
+			codeIds = append(codeIds, codeId)
+		}
+	}
+
+	for _, id := range ids {
+		config, exist := s.configs[id]
+		if !exist || !config.IsValid() {
+			continue
+		}
+		if grayHit(config, meta) {
+			addCode(config.GrayReleaseCode)
+		}
+		// the fallback of the gray release code
+		addCode(config.ReleasedCode)
+	}
+
+	codes, err := getRecords(codeIds, cached, getCodeCacheKey, "code_id",
+		func(code model.Code) string { return code.CodeID })
+	s.codes = codes
+	if err != nil {
+		s.err = err
+	}
+
+	return s
+}
+
+// getRecords gets the records with the ids, from redis with a single MGET
+// if cached, and from the database for the rest, which are then cached in
+// a single pipeline. Records not found are absent from the map.
+func getRecords[T interface{}](ids []string, cached bool, cacheKey func(string) string,
+	column string, idOf func(T) string) (map[string]T, error) {
+	records := make(map[string]T, len(ids))
+	if len(ids) == 0 {
+		return records, nil
+	}
+
+	missing := ids
+	if cached {
+		keys := make([]string, len(ids))
+		for i, id := range ids {
+			keys[i] = cacheKey(id)
+		}
+
+		if values, err := redis.MGet[T](keys); err == nil {
+			missing = nil
+			for i, value := range values {
+				if value != nil {
+					records[ids[i]] = *value
+				} else {
+					missing = append(missing, ids[i])
+				}
+			}
+		}
+	}
+
+	if len(missing) == 0 {
+		return records, nil
+	}
+
+	var found []T
+	if err := model.DB.Find(&found, column+" IN ?", missing).Error; err != nil {
+		return records, err
+	}
+
+	keys := make([]string, len(found))
+	values := make([]interface{}, len(found))
+	for i, record := range found {
+		id := idOf(record)
+		records[id] = record
+		keys[i] = cacheKey(id)
+		values[i] = record
+	}
+
+	// cache the records in redis
+	expiration := viper.GetDuration("redis-expiration")
+	if len(found) > 0 {
+		if err := redis.SetMany(keys, values, expiration); err != nil {
+			log.Print(err)
+		}
+	}
+
+	return records, nil
+}
@@ -1,26 +1,47 @@
 package config
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"service/internal/engine"
-	"service/internal/engine/javascript"
-	"service/internal/engine/starlark"
+	_ "service/internal/engine/cel"
+	_ "service/internal/engine/es2020"
+	_ "service/internal/engine/javascript"
+	_ "service/internal/engine/lua"
+	_ "service/internal/engine/starlark"
+	_ "service/internal/engine/wasm"
+	"service/internal/library"
 	"service/internal/model"
 	"service/internal/redis"
 	"service/internal/router/resp"
 	"service/internal/utils"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/viper"
 )
 
+// default seconds to wait before retrying when the service is busy
+const defaultRetryAfter = 1
+
+// response versions, defaulting to ResponseVersionString
+const (
+	// the result is the string representation of the returned value
+	ResponseVersionString = 1
+	// the result is the returned value in JSON
+	ResponseVersionJSON = 2
+)
+
 type GetConfigBody struct {
-	Meta   model.ConfigMeta       `json:"meta"`
-	Cached bool                   `json:"cached"`
-	Params map[string]interface{} `json:"params"`
+	Meta            model.ConfigMeta       `json:"meta"`
+	Cached          bool                   `json:"cached"`
+	Params          map[string]interface{} `json:"params"`
+	ResponseVersion int                    `json:"response_version"`
 }
 
 func GetConfig(c *gin.Context) {
@@ -28,35 +49,132 @@ func GetConfig(c *gin.Context) {
 
 	// get config from http body
 	configBody := GetConfigBody{}
-	err := c.ShouldBindJSON(&configBody)
-
-	cached := configBody.Cached
-
-	if err != nil {
+	if err := c.ShouldBindJSON(&configBody); err != nil {
 		resp.Error(c, http.StatusBadRequest, "invalid arguments")
 		return
 	}
 
-	config, err := getConfig(configId, cached)
+	res, err := evaluate(c.Request.Context(), configId, configBody, directSource{configBody.Cached})
 	if err != nil {
-		resp.Error(c, http.StatusBadRequest, fmt.Sprintf("fail to get record: %v", err))
+		respondError(c, err)
 		return
 	}
 
-	if !config.IsValid() {
-		resp.Error(c, http.StatusForbidden, "the config is not active")
+	c.Header("ETag", res.ETag())
+	// the client already has the result
+	if etagMatch(c.GetHeader("If-None-Match"), res.ETag()) {
+		c.Status(http.StatusNotModified)
 		return
 	}
 
+	resp.Ok(c, http.StatusOK, map[string]interface{}{
+		"result":     res.Result,
+		"code_id":    res.CodeID,
+		"from_cache": res.FromCache,
+	})
+}
+
+// configError is a failed evaluation of a config, responded with the status
+type configError struct {
+	status  int
+	msg     string
+	details *engine.ExecError
+	cause   error
+}
+
+func (e *configError) Error() string {
+	return e.msg
+}
+
+func (e *configError) Unwrap() error {
+	return e.cause
+}
+
+func newConfigError(status int, msg string) *configError {
+	return &configError{status: status, msg: msg}
+}
+
+func respondError(c *gin.Context, err *configError) {
+	switch {
+	case errors.Is(err, engine.ErrOverloaded):
+		serviceBusy(c, err.cause)
+	case err.details != nil:
+		resp.ErrorWithDetails(c, err.status, err.msg, err.details, nil)
+	default:
+		resp.Error(c, err.status, err.msg)
+	}
+}
+
+// configSource gets the configs and codes of evaluations
+type configSource interface {
+	config(configId string) (model.Config, error)
+	code(codeId string) (model.Code, error)
+}
+
+// directSource gets each record from redis if cached, or the database
+type directSource struct {
+	cached bool
+}
+
+func (s directSource) config(configId string) (model.Config, error) {
+	return getConfig(configId, s.cached)
+}
+
+func (s directSource) code(codeId string) (model.Code, error) {
+	return getCode(codeId, s.cached)
+}
+
+// whether the device is in the gray release of the config
+func grayHit(config model.Config, meta model.ConfigMeta) bool {
+	bucket, _ := utils.Bucket(meta.DeviceID, config.GrayReleaseCode, 100)
+	return config.Percentage > 0 && bucket < uint32(config.Percentage)
+}
+
+// evaluation is the result of a config
+type evaluation struct {
+	Result    interface{}
+	CodeID    string
+	FromCache bool // served from the result cache
+}
+
+// ETag of the result, a strong validator of the code and the returned
+// value in the response version
+func (e *evaluation) ETag() string {
+	data, _ := json.Marshal(e.Result)
+	return `"` + engine.ContentHash(e.CodeID, string(data)) + `"`
+}
+
+// whether the If-None-Match header matches the ETag, with weak comparison
+func etagMatch(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate the config with the request body, selecting the gray release
+// or the released code
+func evaluate(ctx context.Context, configId string, configBody GetConfigBody, source configSource) (*evaluation, *configError) {
+	config, err := source.config(configId)
+	if err != nil {
+		return nil, newConfigError(http.StatusBadRequest, fmt.Sprintf("fail to get record: %v", err))
+	}
+
+	if !config.IsValid() {
+		return nil, newConfigError(http.StatusForbidden, "the config is not active")
+	}
+
 	// get code
 	var code model.Code
 
-	grayHit := config.Percentage > 0 &&
-		utils.Hash(configBody.Meta.DeviceID+config.GrayReleaseCode)%100 < uint32(config.Percentage)
+	grayHit := grayHit(config, configBody.Meta)
 
 	if grayHit {
 		// use gray release version
-		code, err = getCode(config.GrayReleaseCode, cached)
+		code, err = source.code(config.GrayReleaseCode)
 		if err != nil {
 			log.Printf("fail to find gray release code %s for config %s: %v",
 				config.GrayReleaseCode, configId, err)
@@ -66,7 +184,7 @@ func GetConfig(c *gin.Context) {
 	// if not hit, or the gray release code cannot be used
 	if !grayHit || err != nil || code.IsBroken {
 		// use stable release version
-		code, err = getCode(config.ReleasedCode, cached)
+		code, err = source.code(config.ReleasedCode)
 		if err != nil {
 			log.Printf("fail to find code %s for config %s: %v",
 				config.ReleasedCode, configId, err)
@@ -74,67 +192,131 @@ func GetConfig(c *gin.Context) {
 	}
 
 	if err != nil {
-		resp.Error(c, http.StatusInternalServerError,
+		return nil, newConfigError(http.StatusInternalServerError,
 			fmt.Sprintf("fail to get retrive record: %v", err))
-		return
 	}
 
 	if code.IsBroken {
-		resp.Error(c, http.StatusForbidden, "the requested config is broken")
-		return
+		return nil, newConfigError(http.StatusForbidden, "the requested config is broken")
 	}
 
 	// validate config and parameters
 	if ok, err := code.ValidateRules(configBody.Meta); err != nil {
-		resp.Error(c, http.StatusInternalServerError, err.Error())
-		return
+		return nil, newConfigError(http.StatusInternalServerError, err.Error())
 	} else if !ok {
-		resp.Error(c, http.StatusBadRequest, "request rejected by predefined rules")
-		return
+		return nil, newConfigError(http.StatusBadRequest, "request rejected by predefined rules")
 	}
 
 	params, err := code.ValidateParams(configBody.Params)
-
 	if err != nil {
-		resp.Error(c, http.StatusBadRequest, "fail to validate: "+err.Error())
-		return
+		return nil, newConfigError(http.StatusBadRequest, "fail to validate: "+err.Error())
 	}
 
 	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, newConfigError(http.StatusInternalServerError, "internal error: "+err.Error())
+	}
 
+	meta, err := json.Marshal(configBody.Meta)
 	if err != nil {
-		resp.Error(c, http.StatusInternalServerError, "internal error: "+err.Error())
-		return
+		return nil, newConfigError(http.StatusInternalServerError, "internal error: "+err.Error())
 	}
 
-	var res engine.RunResult
+	runner, err := engine.Get(code.Lang)
+	if err != nil {
+		log.Print(err)
+		return nil, newConfigError(http.StatusInternalServerError, "internal error: "+err.Error())
+	}
 
-	// use config id + code id as compiled code cached id
-	cacheId := configId + code.CodeID
-	if !configBody.Cached {
-		cacheId = ""
+	modules := library.Loader(configBody.Cached)
+
+	// skip the execution if the result is memoized
+	memo := newMemo(code, runner, string(data), configBody.Meta, modules, configBody.Cached)
+	if memo != nil {
+		if memoized, exist := memo.get(); exist {
+			res := engine.RunResult{Val: memoized.Val, JSON: memoized.JSON}
+			return &evaluation{
+				Result:    formatResult(res, configBody.ResponseVersion),
+				CodeID:    code.CodeID,
+				FromCache: true,
+			}, nil
+		}
 	}
 
-	if code.Lang == "starlark" {
-		res = starlark.Run(cacheId, code.Content, string(data))
-	} else if code.Lang == "javascript" {
-		res = javascript.Run(cacheId, code.Content, string(data))
-	} else {
-		msg := "invalid lang " + code.Lang
-		log.Print(msg)
-		resp.Error(c, http.StatusInternalServerError, "internal error: "+msg)
-		return
+	// share the compiled programs with other instances
+	var programs engine.ProgramStore
+	if configBody.Cached {
+		programs = programStore{}
 	}
 
-	if res.Err != nil {
-		resp.Error(c, http.StatusBadRequest, "execution failed: "+res.Err.Error())
-		return
+	if memo != nil {
+		modules = memo.trackModules()
 	}
 
-	resp.Ok(c, http.StatusOK, map[string]interface{}{
-		"result":  res.Val,
-		"code_id": code.CodeID,
+	pool := engine.GetPool(engine.PoolConfig)
+	res := pool.Run(ctx, runner, engine.Task{
+		Code:     code.Content,
+		Params:   string(data),
+		Meta:     string(meta),
+		Decls:    code.Params.Decls(),
+		Budget:   engine.NewBudget(code.Timeout, code.MaxSteps, code.MaxDepth),
+		Modules:  modules,
+		Programs: programs,
 	})
+
+	if errors.Is(res.Err, engine.ErrOverloaded) {
+		return nil, &configError{
+			status: http.StatusServiceUnavailable,
+			msg:    "service busy: " + res.Err.Error(),
+			cause:  res.Err,
+		}
+	}
+
+	if res.Err != nil {
+		return nil, &configError{
+			status:  http.StatusBadRequest,
+			msg:     "execution failed: " + res.Err.Error(),
+			details: engine.Describe(res.Err),
+			cause:   res.Err,
+		}
+	}
+
+	if configBody.ResponseVersion >= ResponseVersionJSON && res.JSON == nil {
+		return nil, newConfigError(http.StatusBadRequest,
+			"execution failed: the returned value is not JSON serializable")
+	}
+
+	if memo != nil {
+		memo.set(res)
+	}
+
+	return &evaluation{
+		Result: formatResult(res, configBody.ResponseVersion),
+		CodeID: code.CodeID,
+	}, nil
+}
+
+// the result in the response version
+func formatResult(res engine.RunResult, version int) interface{} {
+	if version >= ResponseVersionJSON {
+		return res.JSON
+	}
+	return res.Val
+}
+
+// seconds to wait before retrying when the service is busy
+func retryAfter() int {
+	retryAfter := viper.GetInt("retry-after")
+	if retryAfter <= 0 {
+		retryAfter = defaultRetryAfter
+	}
+	return retryAfter
+}
+
+// respond 503 and ask the client to retry later
+func serviceBusy(c *gin.Context, err error) {
+	c.Header("Retry-After", strconv.Itoa(retryAfter()))
+	resp.Error(c, http.StatusServiceUnavailable, "service busy: "+err.Error())
 }
 
 func getConfigCacheKey(configId string) string {
@@ -145,6 +327,27 @@ func getCodeCacheKey(codeId string) string {
 	return "code/" + codeId
 }
 
+func getProgramCacheKey(key string) string {
+	return "program/" + key
+}
+
+// compiled programs stored in redis, shared by all instances
+type programStore struct{}
+
+func (programStore) Get(key string) ([]byte, error) {
+	data, err := redis.GetString(getProgramCacheKey(key))
+	return []byte(data), err
+}
+
+func (programStore) Set(key string, data []byte) error {
+	// programs are keyed by content and expire with the code by default
+	expiration := viper.GetDuration("redis-expiration")
+	if viper.IsSet("program-expiration") {
+		expiration = viper.GetDuration("program-expiration")
+	}
+	return redis.SetString(getProgramCacheKey(key), string(data), expiration)
+}
+
 func getConfig(configId string, cached bool) (model.Config, error) {
 	cacheKey := getConfigCacheKey(configId)
 
@@ -2,11 +2,13 @@ package config_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"regexp"
+	"service/internal/engine"
 	"service/internal/model"
 	"service/internal/redis"
 	"service/internal/router"
@@ -22,6 +24,9 @@ import (
 	"gorm.io/gorm"
 )
 
+const statsSecret = "magid"
+const maxInFlight = 4
+
 var mock sqlmock.Sqlmock
 var redisMock redismock.ClientMock
 
@@ -88,6 +93,9 @@ func TestMain(m *testing.M) {
 	viper.SetDefault("timeout", 50)
 	viper.SetDefault("allow-origins", []string{"*"})
 	viper.SetDefault("redis-expiration", 60)
+	viper.SetDefault("stats-secret", statsSecret)
+	viper.SetDefault("max-in-flight", maxInFlight)
+	viper.SetDefault("queue-timeout", 1)
 
 	db, sqlMock, err := sqlmock.New()
 	if err != nil {
@@ -303,3 +311,301 @@ func TestMissGrayRelease(t *testing.T) {
 	assert.Equal(t, http.StatusOK, code, "wrong return code")
 	assert.Equal(t, "\"grayrelease\"", res, "wrong gray scale hit")
 }
+
+func TestEngineStats(t *testing.T) {
+	w := testRequest("GET", "/stats/engine", []byte{})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "missing secret accepted")
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/stats/engine", nil)
+	req.Header.Add("Secret", statsSecret)
+	router.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var res struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	// pools are created on first use
+	assert.Contains(t, res.Data, engine.PoolConfig)
+	pool := res.Data[engine.PoolConfig].(map[string]interface{})
+	assert.Contains(t, pool, "admission")
+	assert.Contains(t, pool["engines"], "starlark")
+	assert.Contains(t, pool["engines"], "javascript")
+}
+
+func TestJSONResponse(t *testing.T) {
+	setConfigMockReturn(model.Config{
+		ConfigID:     "100000",
+		ReleasedCode: "1",
+		Status:       "valid",
+	})
+	setCodeMockReturn(ReleasedCodes["release"])
+
+	body, _ := json.Marshal(config.GetConfigBody{
+		Params:          map[string]interface{}{},
+		ResponseVersion: config.ResponseVersionJSON,
+	})
+
+	w := testRequest("POST", "/config/100000", body)
+	assert.Equal(t, http.StatusOK, w.Code, "wrong return code")
+
+	var res struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, "release", res.Data["result"], "wrong JSON result")
+}
+
+func TestServiceBusy(t *testing.T) {
+	for i := 0; i < maxInFlight; i++ {
+		release, err := engine.GetPool(engine.PoolConfig).Limiter().Acquire(context.Background())
+		assert.NoError(t, err)
+		defer release()
+	}
+
+	setConfigMockReturn(model.Config{
+		ConfigID:     "100000",
+		ReleasedCode: "empty",
+		Status:       "valid",
+	})
+	setCodeMockReturn(Codes["empty"])
+
+	w := testRequest("POST", "/config/100000", createBody(model.ConfigMeta{}, map[string]interface{}{}))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+}
+
+func batchRequest(body config.GetConfigsBody) (int, map[string]config.ConfigResult) {
+	data, _ := json.Marshal(body)
+	w := testRequest("POST", "/configs", data)
+
+	var res struct {
+		Data struct {
+			Configs map[string]config.ConfigResult `json:"configs"`
+		} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	return w.Code, res.Data.Configs
+}
+
+func TestBatch(t *testing.T) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `config` WHERE config_id IN (?,?)")).
+		WithArgs("a", "b").
+		WillReturnRows(configRow(model.Config{ConfigID: "a", ReleasedCode: "1", Status: "valid"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `code` WHERE code_id IN (?)")).
+		WithArgs("1").
+		WillReturnRows(codeRow(ReleasedCodes["release"]))
+
+	code, results := batchRequest(config.GetConfigsBody{
+		Configs: map[string]map[string]interface{}{"a": {}, "b": {}},
+	})
+	assert.Equal(t, http.StatusOK, code, "partial failure failed the batch")
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, http.StatusOK, results["a"].Status)
+	assert.Equal(t, `"release"`, results["a"].Result)
+	assert.Equal(t, "1", results["a"].CodeID)
+
+	assert.Equal(t, http.StatusBadRequest, results["b"].Status)
+	assert.Contains(t, results["b"].Error, "record not found")
+}
+
+func TestBatchCached(t *testing.T) {
+	configData, _ := json.Marshal(model.Config{ConfigID: "a", ReleasedCode: "1", Status: "valid"})
+	codeData, _ := json.Marshal(ReleasedCodes["release"])
+
+	// a single MGET for each kind of records
+	redisMock.ClearExpect()
+	redisMock.ExpectMGet("config/a", "config/b").SetVal([]interface{}{string(configData), nil})
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `config` WHERE config_id IN (?)")).
+		WithArgs("b").
+		WillReturnRows(sqlmock.NewRows([]string{"config_id"}))
+	redisMock.ExpectMGet("code/1").SetVal([]interface{}{string(codeData)})
+
+	code, results := batchRequest(config.GetConfigsBody{
+		Cached:  true,
+		Configs: map[string]map[string]interface{}{"a": {}, "b": {}},
+	})
+	assert.Equal(t, http.StatusOK, code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.NoError(t, redisMock.ExpectationsWereMet())
+
+	assert.Equal(t, `"release"`, results["a"].Result)
+	assert.Equal(t, http.StatusBadRequest, results["b"].Status)
+}
+
+func TestBatchInvalid(t *testing.T) {
+	code, _ := batchRequest(config.GetConfigsBody{})
+	assert.Equal(t, http.StatusBadRequest, code, "empty batch accepted")
+
+	viper.Set("max-batch-size", 1)
+	defer viper.Set("max-batch-size", nil)
+
+	code, _ = batchRequest(config.GetConfigsBody{
+		Configs: map[string]map[string]interface{}{"a": {}, "b": {}},
+	})
+	assert.Equal(t, http.StatusBadRequest, code, "batch size not limited")
+}
+
+func TestBatchServiceBusy(t *testing.T) {
+	for i := 0; i < maxInFlight; i++ {
+		release, err := engine.GetPool(engine.PoolConfig).Limiter().Acquire(context.Background())
+		assert.NoError(t, err)
+		defer release()
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `config` WHERE config_id IN (?,?)")).
+		WithArgs("a", "b").
+		WillReturnRows(configRow(model.Config{ConfigID: "a", ReleasedCode: "1", Status: "valid"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `code` WHERE code_id IN (?)")).
+		WithArgs("1").
+		WillReturnRows(codeRow(ReleasedCodes["release"]))
+
+	data, _ := json.Marshal(config.GetConfigsBody{
+		Configs: map[string]map[string]interface{}{"a": {}, "b": {}},
+	})
+	w := testRequest("POST", "/configs", data)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"), "rejected configs not retried")
+
+	var res struct {
+		Data struct {
+			Configs map[string]config.ConfigResult `json:"configs"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, http.StatusServiceUnavailable, res.Data.Configs["a"].Status)
+	assert.Equal(t, 1, res.Data.Configs["a"].RetryAfter)
+	assert.Zero(t, res.Data.Configs["b"].RetryAfter, "failed config retried")
+}
+
+func TestETag(t *testing.T) {
+	request := func(etag string) *httptest.ResponseRecorder {
+		setConfigMockReturn(model.Config{
+			ConfigID:     "100000",
+			ReleasedCode: "1",
+			Status:       "valid",
+		})
+		setCodeMockReturn(ReleasedCodes["release"])
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/config/100000",
+			bytes.NewReader(createBody(model.ConfigMeta{}, map[string]interface{}{})))
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		router.Router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("")
+	assert.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag, "missing ETag")
+
+	w = request(etag)
+	assert.Equal(t, http.StatusNotModified, w.Code, "unchanged result fetched again")
+	assert.Empty(t, w.Body.Bytes(), "body of 304 not empty")
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+
+	w = request(`"other", W/` + etag)
+	assert.Equal(t, http.StatusNotModified, w.Code, "ETag list not matched")
+
+	w = request(`"other"`)
+	assert.Equal(t, http.StatusOK, w.Code, "mismatched ETag not fetched")
+}
+
+func TestResultCache(t *testing.T) {
+	request := func(meta model.ConfigMeta) (string, bool) {
+		setConfigMockReturn(model.Config{
+			ConfigID:     "100000",
+			ReleasedCode: "memo",
+			Status:       "valid",
+		})
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM `+"`code`") + "(.+)").
+			WithArgs(sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"code_id", "code", "lang", "result_ttl"}).
+				AddRow("memo", `return meta["version"] * 2`, "starlark", 60))
+
+		w := testRequest("POST", "/config/100000", createBody(meta, map[string]interface{}{}))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var res struct {
+			Data struct {
+				Result    string `json:"result"`
+				FromCache bool   `json:"from_cache"`
+			} `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return res.Data.Result, res.Data.FromCache
+	}
+
+	res, fromCache := request(model.ConfigMeta{Version: 3, Platform: "ios"})
+	assert.Equal(t, "6", res)
+	assert.False(t, fromCache, "result served from an empty cache")
+
+	// the platform is not read by the code
+	res, fromCache = request(model.ConfigMeta{Version: 3, Platform: "android"})
+	assert.Equal(t, "6", res)
+	assert.True(t, fromCache, "result not served from cache")
+
+	res, fromCache = request(model.ConfigMeta{Version: 4, Platform: "ios"})
+	assert.Equal(t, "8", res)
+	assert.False(t, fromCache, "result of other meta served from cache")
+}
+
+func TestResultCacheModules(t *testing.T) {
+	expectLibrary := func(version int, content string) func() {
+		return func() {
+			mock.ExpectQuery("SELECT (.+) FROM `library` WHERE name = (.+) AND lang = (.+) ORDER BY version desc").
+				WithArgs("lib/factor", "starlark").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "name", "version", "lang", "code"}).
+					AddRow(version, "lib/factor", version, "starlark", content))
+		}
+	}
+
+	request := func(libraries ...func()) (string, bool) {
+		setConfigMockReturn(model.Config{
+			ConfigID:     "100001",
+			ReleasedCode: "memo-modules",
+			Status:       "valid",
+		})
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM `+"`code`") + "(.+)").
+			WithArgs(sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"code_id", "code", "lang", "result_ttl"}).
+				AddRow("memo-modules", "load(\"lib/factor\", \"factor\")\nreturn meta[\"version\"] * factor", "starlark", 60))
+		for _, expect := range libraries {
+			expect()
+		}
+
+		w := testRequest("POST", "/config/100001", createBody(model.ConfigMeta{Version: 3}, map[string]interface{}{}))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var res struct {
+			Data struct {
+				Result    string `json:"result"`
+				FromCache bool   `json:"from_cache"`
+			} `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return res.Data.Result, res.Data.FromCache
+	}
+
+	res, fromCache := request(expectLibrary(1, "factor = 2"))
+	assert.Equal(t, "6", res)
+	assert.False(t, fromCache, "result served from an empty cache")
+
+	// the module still resolves to the same version
+	res, fromCache = request(expectLibrary(1, "factor = 2"))
+	assert.Equal(t, "6", res)
+	assert.True(t, fromCache, "result not served from cache")
+
+	// a new version of the unpinned module is published
+	// checked, then loaded by the execution
+	res, fromCache = request(expectLibrary(2, "factor = 3"), expectLibrary(2, "factor = 3"))
+	assert.Equal(t, "9", res)
+	assert.False(t, fromCache, "result of an outdated module served from cache")
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"service/internal/engine"
+	"service/internal/engine/cache"
+	"service/internal/model"
+	"service/internal/redis"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// memoized result of a code, valid until expires
+type memoResult struct {
+	Val  string          `json:"val"`
+	JSON json.RawMessage `json:"json"`
+	// library modules looked up by the execution
+	Modules []memoModule `json:"modules,omitempty"`
+	Expires time.Time    `json:"expires"`
+}
+
+// memoModule is a lookup of a library module, with the key of the module
+// found, or empty if not found
+type memoModule struct {
+	Lang string `json:"lang"`
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// in-process results, in front of the results in redis
+var (
+	localResults     *cache.LRU[*memoResult]
+	localResultsOnce sync.Once
+)
+
+func getLocalResults() *cache.LRU[*memoResult] {
+	localResultsOnce.Do(func() {
+		// entries expire with their own ttl
+		localResults = cache.New[*memoResult](viper.GetInt("result-cache-size"), 0)
+	})
+	return localResults
+}
+
+func getResultCacheKey(key string) string {
+	return "result/" + key
+}
+
+// memo is the result cache entry of an execution of a code whose results
+// are memoized
+type memo struct {
+	key    string
+	ttl    time.Duration
+	cached bool // whether to use redis
+	loader engine.ModuleLoader
+
+	mu      sync.Mutex
+	modules []memoModule // modules looked up by the execution
+}
+
+// newMemo returns the entry of the execution, keyed by the code content,
+// the validated params and the meta fields read by the code, or nil if
+// the results of the code are not memoized. Results are only valid while
+// the library modules loaded by the code resolve to the same versions.
+func newMemo(code model.Code, runner engine.Engine, params string, meta model.ConfigMeta,
+	loader engine.ModuleLoader, cached bool) *memo {
+	if code.ResultTTL <= 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return nil
+	}
+
+	// key by the whole meta unless the fields read are known
+	if reader, ok := runner.(engine.MetaReader); ok {
+		if fields, ok := reader.MetaFields(code.Content); ok {
+			var all map[string]interface{}
+			json.Unmarshal(data, &all)
+
+			read := make(map[string]interface{}, len(fields))
+			for _, field := range fields {
+				if val, exist := all[field]; exist {
+					read[field] = val
+				}
+			}
+			data, _ = json.Marshal(read)
+		}
+	}
+
+	return &memo{
+		key:    engine.ContentHash(code.Lang, code.Content, params, string(data)),
+		ttl:    time.Duration(code.ResultTTL) * time.Second,
+		cached: cached,
+		loader: loader,
+	}
+}
+
+// trackModules returns the loader of the execution, recording the modules
+// looked up, including the ones not found as an engine may fall back to
+// other modules
+func (m *memo) trackModules() engine.ModuleLoader {
+	return func(lang, name string) (*engine.Module, error) {
+		module, err := m.loader(lang, name)
+		lookup := memoModule{Lang: lang, Name: name}
+		if err == nil {
+			lookup.Key = module.Key()
+		} else if !errors.Is(err, engine.ErrUnknownModule) {
+			return module, err
+		}
+
+		m.mu.Lock()
+		m.modules = append(m.modules, lookup)
+		m.mu.Unlock()
+		return module, err
+	}
+}
+
+// whether the modules looked up by the result still resolve the same,
+// e.g. a new version of an unpinned module is not published
+func (m *memo) current(res *memoResult) bool {
+	for _, lookup := range res.Modules {
+		module, err := m.loader(lookup.Lang, lookup.Name)
+		if lookup.Key == "" {
+			if !errors.Is(err, engine.ErrUnknownModule) {
+				return false
+			}
+		} else if err != nil || module.Key() != lookup.Key {
+			return false
+		}
+	}
+	return true
+}
+
+// get the result from the local cache, then from redis
+func (m *memo) get() (*memoResult, bool) {
+	local := getLocalResults()
+	if res, exist := local.Get(m.key); exist && time.Now().Before(res.Expires) {
+		return res, m.current(res)
+	}
+
+	if !m.cached {
+		return nil, false
+	}
+
+	res, err := redis.Get[memoResult](getResultCacheKey(m.key))
+	if err != nil || !time.Now().Before(res.Expires) {
+		return nil, false
+	}
+
+	local.Add(m.key, res)
+	return res, m.current(res)
+}
+
+// set the result, unless it is not serializable
+func (m *memo) set(res engine.RunResult) {
+	if res.JSON == nil {
+		return
+	}
+
+	memoized := &memoResult{
+		Val:     res.Val,
+		JSON:    res.JSON,
+		Expires: time.Now().Add(m.ttl),
+	}
+
+	m.mu.Lock()
+	memoized.Modules = m.modules
+	m.mu.Unlock()
+	getLocalResults().Add(m.key, memoized)
+
+	if m.cached {
+		if err := redis.Set(getResultCacheKey(m.key), memoized, m.ttl); err != nil {
+			log.Print(err)
+		}
+	}
+}
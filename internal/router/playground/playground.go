@@ -4,9 +4,16 @@ import (
 	"encoding/json"
 	"net/http"
 	"service/internal/engine"
-	"service/internal/engine/javascript"
-	"service/internal/engine/starlark"
+	_ "service/internal/engine/cel"
+	_ "service/internal/engine/es2020"
+	_ "service/internal/engine/javascript"
+	_ "service/internal/engine/lua"
+	_ "service/internal/engine/starlark"
+	_ "service/internal/engine/wasm"
+	"service/internal/library"
+	"service/internal/model"
 	"service/internal/router/resp"
+	"service/internal/router/validate"
 
 	"github.com/gin-gonic/gin"
 )
@@ -14,9 +21,10 @@ import (
 type PlaygroundRequestBody struct {
 	Code   string                 `json:"code"`
 	Params map[string]interface{} `json:"params"`
+	Meta   model.ConfigMeta       `json:"meta"`
 }
 
-func run(c *gin.Context, runner func(string, string, string) engine.RunResult) {
+func run(c *gin.Context, lang string) {
 	var body PlaygroundRequestBody
 	if err := c.ShouldBindJSON(&body); err != nil {
 		resp.Error(c, http.StatusBadRequest, err.Error())
@@ -30,20 +38,61 @@ func run(c *gin.Context, runner func(string, string, string) engine.RunResult) {
 		return
 	}
 
-	res := runner("", body.Code, string(data))
+	meta, err := json.Marshal(body.Meta)
+
+	if err != nil {
+		resp.Error(c, http.StatusBadRequest, "invalid meta: "+err.Error())
+		return
+	}
+
+	runner, err := engine.Get(lang)
+	if err != nil {
+		resp.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	pool := engine.GetPool(engine.PoolPlayground)
+	res := pool.Run(c.Request.Context(), runner, engine.Task{
+		Code:        body.Code,
+		Params:      string(data),
+		Meta:        string(meta),
+		CaptureLogs: true,
+		Modules:     library.Loader(false),
+	})
 
 	if res.Err != nil {
-		resp.Error(c, http.StatusBadRequest, res.Err.Error())
+		resp.ErrorWithDetails(c, http.StatusBadRequest, res.Err.Error(),
+			engine.Describe(res.Err), res.Logs)
 		return
 	}
 
-	resp.Ok(c, http.StatusOK, res.Val)
+	resp.OkWithLogs(c, http.StatusOK, res.Val, res.Logs)
 }
 
 func Starlark(c *gin.Context) {
-	run(c, starlark.Run)
+	run(c, "starlark")
 }
 
 func JavaScript(c *gin.Context) {
-	run(c, javascript.Run)
+	run(c, "javascript")
+}
+
+func JavaScriptES2020(c *gin.Context) {
+	run(c, "javascript-es2020")
+}
+
+func Lua(c *gin.Context) {
+	run(c, "lua")
+}
+
+func CEL(c *gin.Context) {
+	run(c, "cel")
+}
+
+func WASM(c *gin.Context) {
+	run(c, "wasm")
+}
+
+func ValidateCode(c *gin.Context) {
+	validate.Validate(c, engine.GetPool(engine.PoolPlayground))
 }
@@ -13,9 +13,22 @@ type client struct {
 	configId string
 }
 
+// ConfigUpdateNotification notifies the clients of an updated config, with
+// the versions of the config at the time of the update. Version hashes the
+// versions with the content of the codes and the latest versions of the
+// libraries. Results only change with the version and the request, so a
+// client whose params and meta are unchanged may skip fetching the config if
+// the version equals the one of the notification it last fetched after. It
+// must fetch if the version is empty, which is the case if it cannot be
+// computed.
 type ConfigUpdateNotification struct {
-	UpdateTime int64  `json:"update_time"`
-	ConfigID   string `json:"config_id"`
+	UpdateTime      int64  `json:"update_time"`
+	ConfigID        string `json:"config_id"`
+	ReleasedCode    string `json:"code_release,omitempty"`
+	GrayReleaseCode string `json:"code_gray,omitempty"`
+	Percentage      int    `json:"percentage"`
+	Status          string `json:"status,omitempty"`
+	Version         string `json:"version,omitempty"`
 }
 
 type pushService struct {
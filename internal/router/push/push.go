@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"service/internal/engine"
 	"service/internal/model"
 	"service/internal/router/resp"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -55,16 +57,70 @@ func handleUpdate(c *gin.Context) {
 		return
 	}
 
-	// send update notification
-	num := sendUpdateNotification(ConfigUpdateNotification{
+	notification := ConfigUpdateNotification{
 		ConfigID:   configId,
 		UpdateTime: time.Now().Unix(),
-	})
+	}
+
+	// include the current versions, or notify without them
+	var config model.Config
+	if err := model.DB.First(&config, "config_id = ?", configId).Error; err != nil {
+		log.Printf("fail to get versions of config %s: %v", configId, err)
+	} else {
+		notification.ReleasedCode = config.ReleasedCode
+		notification.GrayReleaseCode = config.GrayReleaseCode
+		notification.Percentage = config.Percentage
+		notification.Status = config.Status
+
+		version, err := configVersion(config)
+		if err != nil {
+			log.Printf("fail to get content version of config %s: %v", configId, err)
+		}
+		notification.Version = version
+	}
+
+	// send update notification
+	num := sendUpdateNotification(notification)
 
 	// send response
 	resp.Ok(c, http.StatusOK, map[string]int{"client_num": num})
 }
 
+// configVersion hashes everything a result of the config depends on besides
+// the request: the config versions, the content of its codes, and the latest
+// versions of the libraries, which may be loaded by the codes
+func configVersion(config model.Config) (string, error) {
+	parts := []string{config.Status, strconv.Itoa(config.Percentage)}
+
+	for _, codeId := range []string{config.ReleasedCode, config.GrayReleaseCode} {
+		if codeId == "" {
+			parts = append(parts, "")
+			continue
+		}
+
+		var code model.Code
+		if err := model.DB.First(&code, "code_id = ?", codeId).Error; err != nil {
+			return "", fmt.Errorf("code record %s: %w", codeId, err)
+		}
+		parts = append(parts, code.CodeID, code.Lang, code.Content)
+	}
+
+	var libraries []model.Library
+	if err := model.DB.Model(&model.Library{}).
+		Select("lang, name, MAX(version) AS version").
+		Group("lang, name").
+		Order("lang, name").
+		Find(&libraries).Error; err != nil {
+		return "", fmt.Errorf("library versions: %w", err)
+	}
+
+	for _, library := range libraries {
+		parts = append(parts, library.Lang, library.Name, strconv.Itoa(library.Version))
+	}
+
+	return engine.ContentHash(parts...), nil
+}
+
 type ErrorReportBody struct {
 	ErrTime int    `json:"err_time"`
 	Message string `json:"message"`
@@ -0,0 +1,237 @@
+package push_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"service/internal/model"
+	"service/internal/router/push"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/websocket"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+const updateSecret = "magid"
+const configSecret = "secret"
+
+var mock sqlmock.Sqlmock
+
+func TestMain(m *testing.M) {
+	// set default configuration values
+	viper.SetDefault("update-secret", updateSecret)
+	viper.SetDefault("websocket", map[string]interface{}{"ping": 1000, "pong": 1000})
+
+	db, sqlMock, err := sqlmock.New()
+	if err != nil {
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	mock = sqlMock
+
+	model.DB, err = gorm.Open(mysql.New(mysql.Config{
+		Conn:                      db,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{})
+
+	if err != nil {
+		os.Exit(1)
+	}
+
+	push.Setup()
+
+	m.Run()
+}
+
+func expectConfig(config model.Config) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM `+"`config`") + "(.+)").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"config_id", "code_release", "code_gray", "percentage", "secret", "status",
+		}).AddRow(
+			config.ConfigID, config.ReleasedCode, config.GrayReleaseCode,
+			config.Percentage, config.Secret, config.Status,
+		))
+}
+
+func expectNoConfig() {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM `+"`config`") + "(.+)").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnError(gorm.ErrRecordNotFound)
+}
+
+func expectCode(code model.Code) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM `+"`code`") + "(.+)").
+		WithArgs(code.CodeID).
+		WillReturnRows(sqlmock.NewRows([]string{"code_id", "lang", "code"}).
+			AddRow(code.CodeID, code.Lang, code.Content))
+}
+
+func expectLibraries(libraries ...model.Library) {
+	rows := sqlmock.NewRows([]string{"lang", "name", "version"})
+	for _, library := range libraries {
+		rows.AddRow(library.Lang, library.Name, library.Version)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT lang, name, MAX(version) AS version FROM `library`")).
+		WillReturnRows(rows)
+}
+
+// connect a client to the push service, which is registered after the
+// connection is set up
+func connect(t *testing.T, server *httptest.Server, configID string) *websocket.Conn {
+	expectConfig(model.Config{ConfigID: configID, Secret: configSecret, Status: "valid"})
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/push/" + configID
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Secret": {configSecret}})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return conn
+}
+
+func update(configID string, secret string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/update/"+configID, nil)
+	req.Header.Set("Secret", secret)
+	push.Router.ServeHTTP(w, req)
+
+	return w
+}
+
+// update the config until the client is registered, returning the
+// notification received
+func notify(t *testing.T, conn *websocket.Conn, configID string, expect func()) push.ConfigUpdateNotification {
+	for i := 0; i < 100; i++ {
+		expect()
+		w := update(configID, updateSecret)
+		assert.Equal(t, http.StatusOK, w.Code)
+		if strings.Contains(w.Body.String(), `"client_num":1`) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var notification push.ConfigUpdateNotification
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	assert.NoError(t, conn.ReadJSON(&notification))
+	return notification
+}
+
+func TestUpdateNotification(t *testing.T) {
+	server := httptest.NewServer(push.Router)
+	defer server.Close()
+
+	// the clients of previous runs are registered until written to, so use
+	// another config for each run, as several notifications are read
+	configID := "123-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	conn := connect(t, server, configID)
+	defer conn.Close()
+
+	config := model.Config{
+		ConfigID:        configID,
+		ReleasedCode:    "release",
+		GrayReleaseCode: "gray",
+		Percentage:      30,
+		Secret:          configSecret,
+		Status:          "valid",
+	}
+	release := model.Code{CodeID: "release", Lang: "starlark", Content: `load("lib/a", "a")`}
+	gray := model.Code{CodeID: "gray", Lang: "starlark", Content: `result = 1`}
+	library := model.Library{Lang: "starlark", Name: "lib/a", Version: 1}
+
+	expect := func() {
+		expectConfig(config)
+		expectCode(release)
+		expectCode(gray)
+		expectLibraries(library)
+	}
+	notification := notify(t, conn, configID, expect)
+
+	assert.Equal(t, configID, notification.ConfigID)
+	assert.Equal(t, "release", notification.ReleasedCode)
+	assert.Equal(t, "gray", notification.GrayReleaseCode)
+	assert.Equal(t, 30, notification.Percentage)
+	assert.Equal(t, "valid", notification.Status)
+	assert.NotZero(t, notification.UpdateTime)
+	assert.NotEmpty(t, notification.Version)
+
+	// the version is unchanged if nothing the results depend on is changed
+	same := notify(t, conn, configID, expect)
+	assert.Equal(t, notification.Version, same.Version, "version changed without updates")
+
+	// and changed with a new version of a library
+	library.Version = 2
+	updated := notify(t, conn, configID, expect)
+	assert.NotEqual(t, notification.Version, updated.Version, "library update not versioned")
+
+	// or new content of the codes
+	gray.Content = `result = 2`
+	updated = notify(t, conn, configID, expect)
+	assert.NotEqual(t, notification.Version, updated.Version, "code update not versioned")
+
+	// or another percentage of the gray release
+	config.Percentage = 50
+	updated = notify(t, conn, configID, expect)
+	assert.NotEqual(t, notification.Version, updated.Version, "config update not versioned")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateNotificationWithoutCode(t *testing.T) {
+	server := httptest.NewServer(push.Router)
+	defer server.Close()
+
+	const configID = "125"
+	conn := connect(t, server, configID)
+	defer conn.Close()
+
+	config := model.Config{ConfigID: configID, ReleasedCode: "release", Status: "valid"}
+
+	// the version is omitted if it cannot be computed, so that the client
+	// fetches the config
+	notification := notify(t, conn, configID, func() {
+		expectConfig(config)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM `+"`code`") + "(.+)").
+			WithArgs("release").
+			WillReturnError(gorm.ErrRecordNotFound)
+	})
+
+	assert.Equal(t, "release", notification.ReleasedCode)
+	assert.Empty(t, notification.Version)
+}
+
+func TestUpdateNotificationWithoutVersions(t *testing.T) {
+	server := httptest.NewServer(push.Router)
+	defer server.Close()
+
+	const configID = "124"
+	conn := connect(t, server, configID)
+	defer conn.Close()
+
+	// the versions are omitted if the config cannot be loaded
+	notification := notify(t, conn, configID, expectNoConfig)
+
+	assert.Equal(t, configID, notification.ConfigID)
+	assert.Empty(t, notification.ReleasedCode)
+	assert.Empty(t, notification.GrayReleaseCode)
+	assert.Zero(t, notification.Percentage)
+	assert.Empty(t, notification.Status)
+	assert.Empty(t, notification.Version)
+	assert.NotZero(t, notification.UpdateTime)
+}
+
+func TestUpdateSecret(t *testing.T) {
+	w := update("123", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code, "missing secret")
+
+	w = update("123", "other")
+	assert.Equal(t, http.StatusForbidden, w.Code, "mismatched secret")
+}
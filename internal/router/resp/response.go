@@ -7,6 +7,8 @@ import (
 type Response struct {
 	Data interface{} `json:"data"`
 	Msg  string      `json:"message"`
+	// captured output of the executed code, only for debugging services
+	Logs []string `json:"logs,omitempty"`
 }
 
 func Ok(c *gin.Context, code int, data interface{}) {
@@ -21,3 +23,20 @@ func Error(c *gin.Context, code int, msg string) {
 		Msg: msg,
 	})
 }
+
+func OkWithLogs(c *gin.Context, code int, data interface{}, logs []string) {
+	c.JSON(code, Response{
+		Data: data,
+		Msg:  "success",
+		Logs: logs,
+	})
+}
+
+// ErrorWithDetails responds an error with its details as the data
+func ErrorWithDetails(c *gin.Context, code int, msg string, data interface{}, logs []string) {
+	c.JSON(code, Response{
+		Data: data,
+		Msg:  msg,
+		Logs: logs,
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"service/internal/router/config"
 	"service/internal/router/playground"
+	"service/internal/router/stats"
 	"service/internal/router/unittest"
 
 	"github.com/gin-contrib/cors"
@@ -33,6 +34,12 @@ func SetupConfigService() {
 	{
 		c.POST("/:config_id", config.GetConfig)
 	}
+	Router.POST("/configs", config.GetConfigs)
+
+	s := Router.Group("/stats")
+	{
+		s.GET("/engine", stats.GetEngineStats)
+	}
 }
 
 func SetupTestService() {
@@ -41,6 +48,7 @@ func SetupTestService() {
 	t := Router.Group("/test")
 	{
 		t.GET("/:test_id", unittest.ExecuteTest)
+		t.POST("/validate", unittest.ValidateCode)
 	}
 }
 
@@ -51,5 +59,10 @@ func SetupPlayground() {
 	{
 		pg.POST("/starlark", playground.Starlark)
 		pg.POST("/js", playground.JavaScript)
+		pg.POST("/es2020", playground.JavaScriptES2020)
+		pg.POST("/lua", playground.Lua)
+		pg.POST("/cel", playground.CEL)
+		pg.POST("/wasm", playground.WASM)
+		pg.POST("/validate", playground.ValidateCode)
 	}
 }
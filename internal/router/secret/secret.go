@@ -0,0 +1,35 @@
+package secret
+
+import (
+	"net/http"
+	"service/internal/router/resp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+// Verify checks the secret of the request initiator against the secret in
+// the setting, responding an error if not matched.
+func Verify(c *gin.Context, setting string) bool {
+	secret := c.GetHeader("Secret")
+
+	if secret == "" {
+		resp.Error(c, http.StatusBadRequest, "missing secret")
+		return false
+	}
+
+	localSecret := viper.GetString(setting)
+
+	if localSecret == "" {
+		resp.Error(c, http.StatusInternalServerError,
+			"secret is not set properly in the server")
+		return false
+	}
+
+	if secret != localSecret {
+		resp.Error(c, http.StatusForbidden, "wrong access secret")
+		return false
+	}
+
+	return true
+}
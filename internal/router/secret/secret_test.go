@@ -0,0 +1,44 @@
+package secret_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"service/internal/router/secret"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func verify(setting string, header string) (bool, int) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/", nil)
+	if header != "" {
+		c.Request.Header.Set("Secret", header)
+	}
+
+	ok := secret.Verify(c, setting)
+	return ok, w.Code
+}
+
+func TestVerify(t *testing.T) {
+	viper.Set("test-secret", "magid")
+	defer viper.Set("test-secret", nil)
+
+	ok, _ := verify("test-secret", "magid")
+	assert.True(t, ok, "matched secret rejected")
+
+	ok, code := verify("test-secret", "")
+	assert.False(t, ok, "missing secret accepted")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	ok, code = verify("test-secret", "other")
+	assert.False(t, ok, "wrong secret accepted")
+	assert.Equal(t, http.StatusForbidden, code)
+
+	ok, code = verify("unset-secret", "magid")
+	assert.False(t, ok, "secret accepted without a server secret")
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
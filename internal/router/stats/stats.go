@@ -0,0 +1,45 @@
+package stats
+
+import (
+	"net/http"
+	"service/internal/engine"
+	"service/internal/engine/cache"
+	"service/internal/router/resp"
+	"service/internal/router/secret"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EngineStats struct {
+	Cache cache.Stats `json:"cache"`
+}
+
+type PoolStats struct {
+	Engines   map[string]EngineStats `json:"engines"`
+	Admission engine.LimiterStats    `json:"admission"`
+}
+
+func GetEngineStats(c *gin.Context) {
+	if !secret.Verify(c, "stats-secret") {
+		return
+	}
+
+	stats := map[string]PoolStats{}
+
+	for _, pool := range engine.Pools() {
+		poolStats := PoolStats{
+			Engines:   map[string]EngineStats{},
+			Admission: pool.Limiter().Stats(),
+		}
+
+		for _, e := range engine.Engines() {
+			poolStats.Engines[e.Name()] = EngineStats{
+				Cache: e.CacheStats(pool),
+			}
+		}
+
+		stats[pool.Name()] = poolStats
+	}
+
+	resp.Ok(c, http.StatusOK, stats)
+}
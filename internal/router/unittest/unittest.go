@@ -2,19 +2,26 @@ package unittest
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"reflect"
 	"service/internal/engine"
-	"service/internal/engine/javascript"
-	"service/internal/engine/starlark"
+	_ "service/internal/engine/cel"
+	_ "service/internal/engine/es2020"
+	_ "service/internal/engine/javascript"
+	_ "service/internal/engine/lua"
+	_ "service/internal/engine/starlark"
+	_ "service/internal/engine/wasm"
+	"service/internal/library"
 	"service/internal/model"
 	"service/internal/router/resp"
+	"service/internal/router/secret"
+	"service/internal/router/validate"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/spf13/viper"
 )
 
 type TestResult struct {
@@ -27,26 +34,16 @@ type TestResultData struct {
 	Succeed  bool  `json:"succeed"`
 }
 
-func ExecuteTest(c *gin.Context) {
-	// verify the identity of the request initiator
-	secret := c.GetHeader("Secret")
-
-	if secret == "" {
-		resp.Error(c, http.StatusBadRequest, "missing secret")
+func ValidateCode(c *gin.Context) {
+	if !secret.Verify(c, "test-secret") {
 		return
 	}
 
-	// verify the secret
-	localSecret := viper.GetString("test-secret")
-
-	if localSecret == "" {
-		resp.Error(c, http.StatusInternalServerError,
-			"secret is not set properly in the server")
-		return
-	}
+	validate.Validate(c, engine.GetPool(engine.PoolTest))
+}
 
-	if secret != localSecret {
-		resp.Error(c, http.StatusForbidden, "wrong access secret")
+func ExecuteTest(c *gin.Context) {
+	if !secret.Verify(c, "test-secret") {
 		return
 	}
 
@@ -86,53 +83,88 @@ func ExecuteTest(c *gin.Context) {
 		return
 	}
 
-	var res engine.RunResult
-	startTime := time.Now()
+	// the meta is optional, with zero values by default
+	var meta model.ConfigMeta
+	if testCase.Meta != "" {
+		if err := json.Unmarshal([]byte(testCase.Meta), &meta); err != nil {
+			resp.Error(c, http.StatusBadRequest, "invalid JSON meta: "+err.Error())
+			return
+		}
+	}
 
-	if testCode.Lang == "starlark" {
-		res = starlark.Run("", testCode.Content, string(inputData))
-	} else if testCode.Lang == "javascript" {
-		res = javascript.Run("", testCode.Content, string(inputData))
-	} else {
-		msg := "invalid lang " + testCode.Lang
-		log.Print(msg)
-		resp.Error(c, http.StatusInternalServerError, "internal error: "+msg)
+	metaData, err := json.Marshal(meta)
+
+	if err != nil {
+		resp.Error(c, http.StatusInternalServerError, "internal error: "+err.Error())
 		return
 	}
 
-	if res.Err != nil {
-		resp.Error(c, http.StatusBadRequest, res.Err.Error())
+	runner, err := engine.Get(testCode.Lang)
+	if err != nil {
+		log.Print(err)
+		resp.Error(c, http.StatusInternalServerError, "internal error: "+err.Error())
 		return
 	}
 
-	duration := int64(time.Since(startTime) / time.Microsecond)
+	startTime := time.Now()
+	pool := engine.GetPool(engine.PoolTest)
+	res := pool.Run(c.Request.Context(), runner, engine.Task{
+		Code:        testCode.Content,
+		Params:      string(inputData),
+		Meta:        string(metaData),
+		Decls:       testCode.Params.Decls(),
+		Budget:      engine.NewBudget(testCode.Timeout, testCode.MaxSteps, testCode.MaxDepth),
+		CaptureLogs: true,
+		Modules:     library.Loader(false),
+	})
 
-	var testResult map[string]interface{}
-	if err := json.Unmarshal([]byte(res.Val), &testResult); err != nil {
-		resp.Error(c, http.StatusBadRequest,
-			"fail to parse JSON from actural output:"+err.Error())
+	if res.Err != nil {
+		resp.ErrorWithDetails(c, http.StatusBadRequest, res.Err.Error(),
+			engine.Describe(res.Err), res.Logs)
 		return
 	}
 
-	var expectedOutput map[string]interface{}
+	duration := int64(time.Since(startTime) / time.Microsecond)
+
+	var expectedOutput interface{}
 	if err := json.Unmarshal([]byte(testCase.Output), &expectedOutput); err != nil {
 		resp.Error(c, http.StatusBadRequest,
 			"fail to parse JSON from expected output:"+err.Error())
 		return
 	}
 
+	// the returned value in JSON, or the legacy result parsed as JSON, e.g.
+	// a string returned by `JSON.stringify`
+	var testResult, legacyResult interface{}
+	jsonErr := errors.New("the returned value is not JSON serializable")
+	if res.JSON != nil {
+		jsonErr = json.Unmarshal(res.JSON, &testResult)
+	}
+	legacyErr := json.Unmarshal([]byte(res.Val), &legacyResult)
+
+	if jsonErr != nil && legacyErr != nil {
+		resp.ErrorWithDetails(c, http.StatusBadRequest,
+			"fail to parse JSON from actural output: "+jsonErr.Error(), nil, res.Logs)
+		return
+	}
+
 	message := "success"
-	matched := reflect.DeepEqual(testResult, expectedOutput)
+	matched := (jsonErr == nil && reflect.DeepEqual(testResult, expectedOutput)) ||
+		(legacyErr == nil && reflect.DeepEqual(legacyResult, expectedOutput))
 
 	if !matched {
-		message = fmt.Sprintf("wrong output:\n%s", res.Val)
+		output := res.JSON
+		if output == nil {
+			output = []byte(res.Val)
+		}
+		message = fmt.Sprintf("wrong output:\n%s", output)
 	}
 
-	resp.Ok(c, http.StatusOK, TestResult{
+	resp.OkWithLogs(c, http.StatusOK, TestResult{
 		Data: TestResultData{
 			Duration: duration,
 			Succeed:  matched,
 		},
 		Message: message,
-	})
+	}, res.Logs)
 }
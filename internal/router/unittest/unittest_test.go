@@ -3,13 +3,16 @@ package unittest_test
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"regexp"
+	"service/internal/engine/javascript"
 	"service/internal/model"
 	"service/internal/router"
 	"service/internal/router/resp"
+	"service/internal/utils"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
@@ -29,6 +32,10 @@ func TestMain(m *testing.M) {
 	viper.SetDefault("timeout", 50)
 	viper.SetDefault("allow-origins", []string{"*"})
 
+	if err := javascript.Init(); err != nil {
+		os.Exit(1)
+	}
+
 	db, sqlMock, err := sqlmock.New()
 	if err != nil {
 		os.Exit(1)
@@ -102,7 +109,19 @@ var invalidOutputs = []string{`
 }
 
 func testRequest(output string) (int, resp.Response) {
-	setMockReturn(output)
+	return testRequestWithCode(testCode, output)
+}
+
+func testRequestWithCode(code string, output string) (int, resp.Response) {
+	return testRequestWithMeta(code, output, "")
+}
+
+func testRequestWithMeta(code string, output string, meta string) (int, resp.Response) {
+	return testRequestWithLang("starlark", code, output, meta)
+}
+
+func testRequestWithLang(lang string, code string, output string, meta string) (int, resp.Response) {
+	setMockReturn(lang, code, output, meta)
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/test/"+testID, bytes.NewReader([]byte{}))
 	req.Header.Add("Secret", secret)
@@ -114,29 +133,29 @@ func testRequest(output string) (int, resp.Response) {
 	return w.Code, response
 }
 
-func testCaseRow(output string) *sqlmock.Rows {
+func testCaseRow(output string, meta string) *sqlmock.Rows {
 	return sqlmock.NewRows([]string{
-		"test_id", "input", "output", "config_id",
+		"test_id", "input", "output", "config_id", "meta",
 	}).AddRow(
-		testID, []byte("{}"), output, configID,
+		testID, []byte("{}"), output, configID, meta,
 	)
 }
 
-func codeRow() *sqlmock.Rows {
+func codeRow(lang string, code string) *sqlmock.Rows {
 	return sqlmock.NewRows([]string{
 		"code_id", "code", "rules", "params", "lang",
 	}).AddRow(
-		codeID, testCode, []byte("[]"), []byte("[]"), "starlark",
+		codeID, code, []byte("[]"), []byte("[]"), lang,
 	)
 }
 
-func setMockReturn(output string) {
+func setMockReturn(lang string, code string, output string, meta string) {
 	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM `+"`unittest`") + "(.+)").
 		WithArgs(sqlmock.AnyArg()).
-		WillReturnRows(testCaseRow(output))
+		WillReturnRows(testCaseRow(output, meta))
 	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM `+"`code`") + "(.+)").
 		WithArgs(sqlmock.AnyArg()).
-		WillReturnRows(codeRow())
+		WillReturnRows(codeRow(lang, code))
 }
 
 func getTestStatus(response resp.Response) bool {
@@ -159,3 +178,92 @@ func TestFailedTest(t *testing.T) {
 		assert.Equal(t, false, succeed)
 	}
 }
+
+func TestStringifiedOutput(t *testing.T) {
+	// the JSON string returned by legacy code is compared as parsed
+	code, response := testRequestWithLang("javascript",
+		`return JSON.stringify({"arr": [1, 2], "obj": {"obj": {"val": 3}, "val": "string"}})`,
+		validOutput, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, getTestStatus(response))
+
+	code, response = testRequestWithLang("javascript",
+		`return JSON.stringify({"arr": [], "obj": {"obj": {"val": 3}, "val": "string"}})`,
+		validOutput, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, getTestStatus(response))
+
+	// as well as the returned string itself
+	code, response = testRequestWithLang("javascript", `return JSON.stringify([1])`, `"[1]"`, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, getTestStatus(response))
+}
+
+func TestCapturedLogs(t *testing.T) {
+	code, response := testRequestWithCode(`
+print("debug")
+return {}
+`, "{}")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, getTestStatus(response))
+	assert.Equal(t, []string{"debug"}, response.Logs)
+}
+
+func TestMeta(t *testing.T) {
+	bucket, _ := utils.Bucket("device", "salt", 100)
+	code, response := testRequestWithMeta(`
+return [meta["platform"], bucket(meta["device_id"], "salt", 100)]
+`, fmt.Sprintf(`["ios", %d]`, bucket),
+		`{"platform": "ios", "device_id": "device"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, getTestStatus(response))
+
+	code, response = testRequestWithMeta(`return meta["version"]`, "0", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, getTestStatus(response), "meta not defaulted")
+}
+
+func TestErrorDetails(t *testing.T) {
+	code, response := testRequestWithCode("x = 1\nreturn (", "{}")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	details := response.Data.(map[string]interface{})
+	assert.Equal(t, "syntax", details["kind"])
+	assert.Equal(t, float64(2), details["line"])
+	assert.Equal(t, float64(9), details["column"])
+}
+
+func TestValidateCode(t *testing.T) {
+	validate := func(body string) (int, resp.Response) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/test/validate", bytes.NewReader([]byte(body)))
+		req.Header.Add("Secret", secret)
+		router.Router.ServeHTTP(w, req)
+
+		var response resp.Response
+		json.Unmarshal(w.Body.Bytes(), &response)
+
+		return w.Code, response
+	}
+
+	code, response := validate(`{
+		"lang": "starlark",
+		"code": "return p[\"version\"]",
+		"params": [{"name": "version", "type": "int"}]
+	}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, response.Data.(map[string]interface{})["valid"])
+
+	code, response = validate(`{
+		"lang": "starlark",
+		"code": "x = a\nreturn b",
+		"params": [{"name": "version", "type": "long"}]
+	}`)
+	assert.Equal(t, http.StatusOK, code)
+	result := response.Data.(map[string]interface{})
+	assert.Equal(t, false, result["valid"])
+	assert.Len(t, result["diagnostics"], 3, "not all diagnostics reported")
+
+	code, _ = validate(`{"lang": "cobol", "code": ""}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
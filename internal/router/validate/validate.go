@@ -0,0 +1,67 @@
+package validate
+
+import (
+	"net/http"
+	"service/internal/engine"
+	_ "service/internal/engine/cel"
+	_ "service/internal/engine/es2020"
+	_ "service/internal/engine/javascript"
+	_ "service/internal/engine/lua"
+	_ "service/internal/engine/starlark"
+	_ "service/internal/engine/wasm"
+	"service/internal/model"
+	"service/internal/router/resp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// kind of diagnostics for the parameter declarations
+const KindParams = "params"
+
+type ValidateRequestBody struct {
+	Lang   string           `json:"lang"`
+	Code   string           `json:"code"`
+	Params model.ParamArray `json:"params"`
+}
+
+type ValidateResult struct {
+	Valid       bool                `json:"valid"`
+	Diagnostics []*engine.ExecError `json:"diagnostics"`
+}
+
+// Validate parses the code against the settings of pool and checks the
+// parameter declarations without executing the code.
+func Validate(c *gin.Context, pool *engine.Pool) {
+	var body ValidateRequestBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		resp.Error(c, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	runner, err := engine.Get(body.Lang)
+	if err != nil {
+		resp.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	diagnostics := []*engine.ExecError{}
+
+	for _, err := range body.Params.Check() {
+		diagnostics = append(diagnostics, &engine.ExecError{
+			Kind:    KindParams,
+			Message: err.Error(),
+		})
+	}
+
+	// check the types against valid declarations if supported
+	if checker, ok := runner.(engine.TypeChecker); ok && len(diagnostics) == 0 {
+		diagnostics = append(diagnostics, checker.Check(body.Code, body.Params.Decls())...)
+	} else {
+		diagnostics = append(diagnostics, runner.Validate(pool, body.Code)...)
+	}
+
+	resp.Ok(c, http.StatusOK, ValidateResult{
+		Valid:       len(diagnostics) == 0,
+		Diagnostics: diagnostics,
+	})
+}
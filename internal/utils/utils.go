@@ -2,7 +2,9 @@ package utils
 
 import (
 	"bytes"
+	"errors"
 	"hash/fnv"
+	"math"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,6 +21,18 @@ func Hash(s string) uint32 {
 	return h.Sum32()
 }
 
+// ErrBucketNum is returned by Bucket for a number of buckets out of range
+var ErrBucketNum = errors.New("n must be a positive integer")
+
+// Bucket maps the key salted with salt into one of n buckets, where n is
+// in (0, math.MaxUint32].
+func Bucket(key string, salt string, n int64) (uint32, error) {
+	if n <= 0 || n > math.MaxUint32 {
+		return 0, ErrBucketNum
+	}
+	return Hash(key+salt) % uint32(n), nil
+}
+
 func Find[T comparable](slice []T, value T) int {
 	for i, v := range slice {
 		if v == value {